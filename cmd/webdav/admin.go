@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/ReanSn0w/wddl/pkg/source"
+	"github.com/umputun/go-flags"
+)
+
+// Команды администрирования очереди
+//
+// Команды используют те же параметры, что и демон, и работают с файлом
+// базы данных напрямую, поэтому демон на это время должен быть остановлен
+var adminCommands = []string{"queue", "status"}
+
+// isAdmin - проверяет, что первый позиционный аргумент
+// является командой администрирования
+//
+// Аргументы разбираются копией параметров, поэтому значения
+// флагов (например, -o queue) не принимаются за команду
+func isAdmin(args []string) bool {
+	probe := opts
+	rest, err := flags.NewParser(&probe, flags.IgnoreUnknown).ParseArgs(args)
+	if err != nil || len(rest) == 0 {
+		return false
+	}
+
+	return slices.Contains(adminCommands, rest[0])
+}
+
+// runAdmin - выполняет команду администрирования и возвращает код выхода
+func runAdmin(args []string) int {
+	parser := flags.NewParser(&opts, flags.Default)
+
+	queueCmd, err := parser.AddCommand("queue", "queue administration", "", &struct{}{})
+	if err == nil {
+		for _, cmd := range []struct {
+			name, description string
+			data              any
+		}{
+			{"list", "list queued, dead and done files", &queueList{}},
+			{"stat", "print queue statistics", &queueStat{}},
+			{"remove", "remove files by id or source glob", &queueRemove{}},
+			{"clear", "remove all files from queue, dead and done lists", &queueClear{}},
+			{"retry-failed", "return failed and dead files to queue", &queueRetryFailed{}},
+			{"add", "add remote file or directory to queue", &queueAdd{}},
+		} {
+			if _, err = queueCmd.AddCommand(cmd.name, cmd.description, "", cmd.data); err != nil {
+				break
+			}
+		}
+	}
+
+	if err == nil {
+		_, err = parser.AddCommand("status", "print daemon or queue status", "", &statusCommand{})
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build commands: %v\n", err)
+		return 2
+	}
+
+	// Ошибки разбора аргументов и выполнения команд выводит парсер
+	_, err = parser.ParseArgs(args)
+
+	var flagsErr *flags.Error
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &flagsErr) && flagsErr.Type == flags.ErrHelp:
+		return 0
+	case errors.As(err, &flagsErr):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// openQueue - открывает очередь задания --job из --db-file
+func openQueue() (*queue.Queue, error) {
+	q, err := queue.NewJob(opts.DBFile, opts.Job)
+	if err == queue.ErrLocked {
+		return nil, fmt.Errorf("%s: %w, stop the daemon or use the HTTP API", opts.DBFile, err)
+	}
+
+	return q, err
+}
+
+// queueFiles - возвращает файлы очереди, списков мертвых
+// и завершенных задач
+func queueFiles(q *queue.Queue) ([]engine.File, error) {
+	list, err := q.List(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dead, err := q.Dead()
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := q.Completed()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Concat(list, dead, done), nil
+}
+
+type queueList struct{}
+
+func (c *queueList) Execute(args []string) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+
+	defer q.Close()
+
+	list, err := queueFiles(q)
+	if err != nil {
+		return err
+	}
+
+	engine.SortFiles(list, engine.Order(opts.Order))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tATTEMPTS\tPRIORITY\tSIZE\tSOURCE\tLAST ERROR")
+	for _, file := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			file.ID, file.Status.State, file.Status.Attempts, file.Priority,
+			file.Size, file.Source, file.Status.LastError)
+	}
+
+	return tw.Flush()
+}
+
+type queueStat struct{}
+
+func (c *queueStat) Execute(args []string) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+
+	defer q.Close()
+
+	stat, err := q.Stat()
+	if err != nil {
+		return err
+	}
+
+	printStat(os.Stdout, stat)
+	return nil
+}
+
+type queueRemove struct {
+	Args struct {
+		Patterns []string `positional-arg-name:"id|glob" required:"1"`
+	} `positional-args:"yes"`
+}
+
+func (c *queueRemove) Execute(args []string) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+
+	defer q.Close()
+
+	list, err := queueFiles(q)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, file := range list {
+		if !matchFile(file, c.Args.Patterns) {
+			continue
+		}
+
+		err = removeFile(q, file)
+		if err != nil {
+			return err
+		}
+
+		removed++
+	}
+
+	fmt.Printf("removed %d files\n", removed)
+	return nil
+}
+
+type queueClear struct{}
+
+func (c *queueClear) Execute(args []string) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+
+	defer q.Close()
+
+	list, err := queueFiles(q)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range list {
+		err = removeFile(q, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("removed %d files\n", len(list))
+	return nil
+}
+
+type queueRetryFailed struct{}
+
+func (c *queueRetryFailed) Execute(args []string) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+
+	defer q.Close()
+
+	list, err := queueFiles(q)
+	if err != nil {
+		return err
+	}
+
+	retried := 0
+	for _, file := range list {
+		if file.Status.State != engine.StateFailed && file.Status.State != engine.StateDead {
+			continue
+		}
+
+		err = q.Retry(file.ID)
+		if err != nil {
+			return fmt.Errorf("retry %s: %w", file.ID, err)
+		}
+
+		retried++
+	}
+
+	fmt.Printf("returned %d files to queue\n", retried)
+	return nil
+}
+
+type queueAdd struct {
+	Priority int `long:"priority" description:"priority of added files"`
+
+	Args struct {
+		Paths []string `positional-arg-name:"remote path" required:"1"`
+	} `positional-args:"yes"`
+}
+
+func (c *queueAdd) Execute(args []string) error {
+	server, user, password, etagMD5 := opts.WebDav.Server, opts.WebDav.User, opts.WebDav.Password, opts.WebDav.ETagMD5
+	config := engineConfig()
+
+	if opts.Job != "" {
+		job, err := findJob(opts.Job)
+		if err != nil {
+			return err
+		}
+
+		config, err = job.engineConfig()
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+
+		server, user, password, etagMD5 = job.Server, job.User, job.Password, *job.ETagMD5
+	}
+
+	client, err := source.Open(server, source.Options{User: user, Password: password, ETagMD5: etagMD5})
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+
+	scanner := files.New(client)
+
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+
+	defer q.Close()
+
+	added := 0
+	for _, source := range c.Args.Paths {
+		source = "/" + strings.Trim(source, "/")
+		if source != config.InputPath && !strings.HasPrefix(source, strings.TrimSuffix(config.InputPath, "/")+"/") {
+			return fmt.Errorf("%s is outside of input path %s", source, config.InputPath)
+		}
+
+		info, err := client.Stat(source)
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", source, err)
+		}
+
+		list := []engine.File{}
+		if info.IsDir() {
+			list, err = scanner.Scan(context.Background(), config, source)
+
+			// Файлы из прочитанных директорий добавляются
+			var partial *engine.ScanError
+			if errors.As(err, &partial) {
+				fmt.Fprintf(os.Stderr, "scan %s: %v\n", source, err)
+			} else if err != nil {
+				return fmt.Errorf("scan %s: %w", source, err)
+			}
+		} else {
+			file := engine.NewFile(config, source, info.Size())
+			file.ModTime = info.ModTime()
+			list = append(list, file)
+		}
+
+		for _, file := range list {
+			if q.Exists(file.ID) == nil {
+				continue
+			}
+
+			file.Priority = c.Priority
+			err = q.Add(file)
+			if err != nil {
+				return err
+			}
+
+			added++
+		}
+	}
+
+	fmt.Printf("added %d files\n", added)
+	return nil
+}
+
+type statusCommand struct{}
+
+// Execute - выводит состояние запущенного демона через HTTP API,
+// если указан --api.listen, иначе статистику очереди из базы данных
+func (c *statusCommand) Execute(args []string) error {
+	if opts.API.Listen != "" {
+		return printDaemonStatus(opts.API.Listen)
+	}
+
+	return (&queueStat{}).Execute(args)
+}
+
+func printDaemonStatus(listen string) error {
+	host := listen
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+
+	client := &http.Client{Timeout: time.Second * 10}
+	prefix := ""
+	if opts.Job != "" {
+		prefix = "/jobs/" + opts.Job
+	}
+
+	resp, err := client.Get("http://" + host + prefix + "/api/status")
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("api status: %s", resp.Status)
+	}
+
+	var report engine.Report
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("paused:    %v\n", report.Paused)
+	fmt.Printf("in window: %v\n", report.InWindow)
+	fmt.Printf("speed:     %d B/s\n", report.Speed)
+	fmt.Printf("estimate:  %s\n", report.Estimate)
+	printStat(os.Stdout, &report.Queue)
+
+	for _, progress := range report.Active {
+		fmt.Printf("active:    %s\n", progress.String())
+	}
+
+	return nil
+}
+
+func printStat(w io.Writer, stat *engine.Stat) {
+	fmt.Fprintf(w, "files:     %d\n", stat.Files)
+	fmt.Fprintf(w, "bytes:     %d\n", stat.FullSize)
+	fmt.Fprintf(w, "active:    %d\n", stat.Active)
+	fmt.Fprintf(w, "failed:    %d\n", stat.Failed)
+	fmt.Fprintf(w, "dead:      %d\n", stat.Dead)
+	fmt.Fprintf(w, "done:      %d\n", stat.Done)
+	fmt.Fprintf(w, "delivered: %d\n", stat.Delivered)
+}
+
+// matchFile - проверяет совпадение идентификатора или источника файла
+// с одним из шаблонов
+func matchFile(file engine.File, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == file.ID || pattern == file.Source {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, file.Source); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeFile - удаляет файл из очереди вместе с временными частями
+func removeFile(q *queue.Queue, file engine.File) error {
+	err := q.Delete(file.ID)
+	if err != nil {
+		return fmt.Errorf("remove %s: %w", file.ID, err)
+	}
+
+	if file.Temp != "" {
+		err = os.RemoveAll(file.Temp)
+		if err != nil {
+			return fmt.Errorf("remove temp of %s: %w", file.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/filter"
+	"github.com/ReanSn0w/wddl/pkg/limiter"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/ReanSn0w/wddl/pkg/server"
+	"github.com/ReanSn0w/wddl/pkg/source"
+	"github.com/ReanSn0w/wddl/pkg/window"
+	"github.com/go-pkgz/lgr"
+	"gopkg.in/yaml.v3"
+)
+
+// Имя задания используется в именах корзин базы данных и в адресах API
+var jobName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// jobsFile - файл --config со списком заданий
+type jobsFile struct {
+	Jobs []Job `yaml:"jobs"`
+}
+
+// Job - задание синхронизации из файла --config
+//
+// Незаданные параметры берутся из параметров запуска
+type Job struct {
+	Name string `yaml:"name"`
+
+	Server   string `yaml:"server"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	ETagMD5  *bool  `yaml:"etag-md5"`
+
+	Input  string `yaml:"input"`
+	Output string `yaml:"output"`
+	Temp   string `yaml:"temp"`
+
+	Threads      int    `yaml:"threads"`
+	FileThreads  int    `yaml:"file-threads"`
+	PartSize     int64  `yaml:"partition-size"`
+	Timeout      int    `yaml:"timeout"`
+	RemoveRemote *bool  `yaml:"remove-remote"`
+	Verify       *bool  `yaml:"verify"`
+	HistoryMode  string `yaml:"history-mode"`
+
+	Priority []string   `yaml:"priority"`
+	Window   []string   `yaml:"window"`
+	Filter   *jobFilter `yaml:"filter"`
+}
+
+type jobFilter struct {
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+	ExcludeDirs []string `yaml:"exclude-dir"`
+	MinSize     int64    `yaml:"min-size"`
+	MaxSize     int64    `yaml:"max-size"`
+}
+
+// loadJobs - читает задания из файла и дополняет их параметрами запуска
+func loadJobs(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var file jobsFile
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(&file)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs declared", path)
+	}
+
+	names := make(map[string]bool, len(file.Jobs))
+	outputs := make(map[string]string, len(file.Jobs))
+
+	for i := range file.Jobs {
+		job := &file.Jobs[i]
+		if !jobName.MatchString(job.Name) {
+			return nil, fmt.Errorf("job %d: name %q must contain only letters, digits, '-' and '_'", i+1, job.Name)
+		}
+
+		if names[job.Name] {
+			return nil, fmt.Errorf("job %s: duplicate name", job.Name)
+		}
+
+		names[job.Name] = true
+		job.defaults()
+
+		output := filepath.Clean(job.Output)
+		if other, ok := outputs[output]; ok {
+			return nil, fmt.Errorf("job %s: output %s is already used by job %s", job.Name, job.Output, other)
+		}
+
+		outputs[output] = job.Name
+	}
+
+	return file.Jobs, nil
+}
+
+// defaults - заполняет незаданные параметры задания параметрами запуска
+func (j *Job) defaults() {
+	set := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+
+	set(&j.Server, opts.WebDav.Server)
+	set(&j.User, opts.WebDav.User)
+	set(&j.Password, opts.WebDav.Password)
+	set(&j.Input, opts.Input)
+	set(&j.Output, opts.Output)
+	set(&j.Temp, filepath.Join(opts.Temp, j.Name))
+	set(&j.HistoryMode, opts.HistoryMode)
+
+	setInt := func(value *int, fallback int) {
+		if *value <= 0 {
+			*value = fallback
+		}
+	}
+
+	setInt(&j.Threads, opts.Threads)
+	setInt(&j.FileThreads, opts.FileThreads)
+	setInt(&j.Timeout, opts.Timeout)
+
+	if j.PartSize <= 0 {
+		j.PartSize = opts.PartSize
+	}
+
+	setBool := func(value **bool, fallback bool) {
+		if *value == nil {
+			*value = &fallback
+		}
+	}
+
+	setBool(&j.ETagMD5, opts.WebDav.ETagMD5)
+	setBool(&j.RemoveRemote, opts.ClearRemote)
+	setBool(&j.Verify, opts.Verify)
+
+	if j.Priority == nil {
+		j.Priority = opts.Priority
+	}
+
+	if j.Window == nil {
+		j.Window = opts.Window.Active
+	}
+
+	if j.Filter == nil {
+		j.Filter = &jobFilter{
+			Include:     opts.Filter.Include,
+			Exclude:     opts.Filter.Exclude,
+			ExcludeDirs: opts.Filter.ExcludeDirs,
+			MinSize:     opts.Filter.MinSize,
+			MaxSize:     opts.Filter.MaxSize,
+		}
+	}
+}
+
+// engineConfig - собирает конфигурацию движка задания
+func (j *Job) engineConfig() (engine.Config, error) {
+	config := engineConfig()
+
+	config.InputPath = j.Input
+	config.OutputPath = j.Output
+	config.TempPath = j.Temp
+	config.TrashPath = filepath.Join(config.TrashPath, j.Name)
+	config.Concurrency = j.Threads
+	config.PartitionThreads = j.FileThreads
+	config.PartitionSize = j.PartSize << 20
+	config.ScanEvery = time.Second * time.Duration(j.Timeout)
+	config.RemoveRemote = *j.RemoveRemote
+	config.VerifyChecksum = *j.Verify
+	config.HistoryMode = engine.HistoryMode(j.HistoryMode)
+
+	switch config.HistoryMode {
+	case engine.HistoryModeMirror, engine.HistoryModeInbox:
+	default:
+		return config, fmt.Errorf("unknown history mode %q", j.HistoryMode)
+	}
+
+	if config.RemoveRemote && config.MirrorDeletes != engine.DeleteModeNone {
+		return config, errors.New("remove-remote can not be combined with --mirror.deletes")
+	}
+
+	filter, err := filter.New(filter.Options{
+		Include:     j.Filter.Include,
+		Exclude:     j.Filter.Exclude,
+		ExcludeDirs: j.Filter.ExcludeDirs,
+		MinSize:     j.Filter.MinSize,
+		MaxSize:     j.Filter.MaxSize,
+	})
+	if err != nil {
+		return config, fmt.Errorf("filter: %w", err)
+	}
+
+	config.Filter = filter
+
+	config.Priorities, err = engine.ParsePriorityRules(j.Priority)
+	if err != nil {
+		return config, fmt.Errorf("priority: %w", err)
+	}
+
+	windows, err := window.Parse(j.Window)
+	if err != nil {
+		return config, fmt.Errorf("window: %w", err)
+	}
+
+	config.Window = windows
+	return config, nil
+}
+
+// findJob - возвращает задание --job из файла --config
+func findJob(name string) (*Job, error) {
+	if opts.Config == "" {
+		return nil, errors.New("--job requires --config")
+	}
+
+	jobs, err := loadJobs(opts.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs {
+		if jobs[i].Name == name {
+			return &jobs[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("job %s is not declared in %s", name, opts.Config)
+}
+
+// runJobs - выполняет задания из файла --config в одном процессе
+//
+// Задания разделяют лимит одновременных загрузок --threads, ограничение
+// скорости --limit.* и базу данных --db-file, в которой очередь и журнал
+// каждого задания хранятся в отдельных корзинах
+func runJobs(ctx context.Context, log lgr.L) {
+	fail := func(format string, args ...any) {
+		log.Logf("[ERROR] "+format, args...)
+		os.Exit(2)
+	}
+
+	if opts.Util.ClearRemote || opts.Upload.Outbox != "" {
+		fail("--util.clear-remote and --upload.outbox can not be combined with --config")
+	}
+
+	jobs, err := loadJobs(opts.Config)
+	if err != nil {
+		fail("config error: %v", err)
+	}
+
+	schedule, err := limiter.ParseSchedule(opts.Limit.Rate, opts.Limit.Schedule)
+	if err != nil {
+		fail("rate limit error: %v", err)
+	}
+
+	fileRate, err := limiter.ParseRate(opts.Limit.FileRate)
+	if err != nil {
+		fail("rate limit error: %v", err)
+	}
+
+	bandwidth := limiter.NewBandwidth(log, schedule, fileRate)
+	slots := limiter.NewSlots(opts.Threads)
+	breaker := limiter.NewBreaker()
+
+	dryRun := targetAction() == ActionDryRun
+
+	// Пробный запуск не должен изменять базу данных
+	openDB := queue.Open
+	if dryRun {
+		openDB = queue.OpenReadOnly
+	}
+
+	db, err := openDB(opts.DBFile)
+	if err != nil {
+		fail("queue error: %v", err)
+	}
+
+	engines := make(map[string]*engine.Engine, len(jobs))
+	servers := make(map[string]*server.Server, len(jobs))
+
+	for _, job := range jobs {
+		config, err := job.engineConfig()
+		if err != nil {
+			fail("job %s: %v", job.Name, err)
+		}
+
+		config.Throttle = bandwidth
+		config.Slots = slots
+		config.Breaker = breaker
+
+		client, err := source.Open(job.Server, source.Options{
+			User:     job.User,
+			Password: job.Password,
+			ETagMD5:  *job.ETagMD5,
+		})
+		if err != nil {
+			fail("job %s: source error: %v", job.Name, err)
+		}
+
+		q, err := db.Queue(job.Name)
+		if err != nil {
+			fail("job %s: queue error: %v", job.Name, err)
+		}
+
+		if opts.Scan.Incremental && !dryRun {
+			config.ScanCache = q
+		}
+
+		files := files.New(client)
+		jobLog := jobLogger(log, job.Name)
+
+		engines[job.Name] = engine.New(jobLog, config, files, files, q, q)
+		servers[job.Name] = server.New(jobLog, engines[job.Name], q, bandwidth)
+	}
+
+	switch targetAction() {
+	case ActionDryRun:
+		plans := make(map[string]*engine.Plan, len(jobs))
+		for _, job := range jobs {
+			plans[job.Name], err = engines[job.Name].Plan(ctx)
+			if err != nil {
+				fail("job %s: dry run error: %v", job.Name, err)
+			}
+		}
+
+		if opts.PlanFormat == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(plans)
+		} else {
+			for _, job := range jobs {
+				fmt.Printf("JOB %s\n", job.Name)
+				if err = plans[job.Name].WriteTable(os.Stdout); err != nil {
+					break
+				}
+
+				fmt.Println()
+			}
+		}
+
+		if err != nil {
+			fail("dry run output error: %v", err)
+		}
+
+		os.Exit(0)
+	case ActionOnce:
+		go bandwidth.Run(ctx)
+
+		var (
+			wg        sync.WaitGroup
+			summaries = make([]*engine.Summary, len(jobs))
+			errs      = make([]error, len(jobs))
+		)
+
+		for i, job := range jobs {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				summaries[i], errs[i] = engines[job.Name].Once(ctx)
+			}()
+		}
+
+		wg.Wait()
+
+		code := 0
+		for i, job := range jobs {
+			if errs[i] != nil {
+				log.Logf("[ERROR] job %s: sync error: %v", job.Name, errs[i])
+				code = 2
+				continue
+			}
+
+			fmt.Printf("%s: %s\n", job.Name, summaries[i])
+			if summaries[i].Failed+summaries[i].ScanErrors > 0 && code == 0 {
+				code = 1
+			}
+		}
+
+		os.Exit(code)
+	default:
+		go bandwidth.Run(ctx)
+
+		if opts.API.Listen != "" {
+			server.Serve(ctx, log, opts.API.Listen, server.Jobs(servers))
+		}
+
+		for _, job := range jobs {
+			engines[job.Name].Start(ctx)
+		}
+	}
+}
+
+// jobLogger - добавляет имя задания к сообщениям журнала
+func jobLogger(log lgr.L, name string) lgr.L {
+	return lgr.Func(func(format string, args ...any) {
+		// Уровень сообщения должен оставаться в начале строки
+		level, message, ok := strings.Cut(format, "] ")
+		if ok && strings.HasPrefix(level, "[") {
+			log.Logf(level+"] "+name+": "+message, args...)
+			return
+		}
+
+		log.Logf(name+": "+format, args...)
+	})
+}
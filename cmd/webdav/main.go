@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"time"
 
 	"git.papkovda.ru/library/gokit/pkg/app"
 	"github.com/ReanSn0w/wddl/pkg/engine"
 	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/filter"
+	"github.com/ReanSn0w/wddl/pkg/limiter"
 	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/ReanSn0w/wddl/pkg/server"
+	"github.com/ReanSn0w/wddl/pkg/source"
 	"github.com/ReanSn0w/wddl/pkg/utils"
-	"github.com/studio-b12/gowebdav"
+	"github.com/ReanSn0w/wddl/pkg/window"
+	"github.com/go-pkgz/lgr"
 )
 
 var (
@@ -21,17 +29,91 @@ var (
 		Temp   string `short:"t" long:"temp" env:"TEMP" default:"/tmp/wddl" description:"temporary path"`
 		Output string `short:"o" long:"output" env:"OUTPUT" default:"./download" description:"output path"`
 
+		Config string `short:"c" long:"config" env:"CONFIG" description:"yaml file with sync jobs run by one process, command line options are job defaults"`
+		Job    string `long:"job" env:"JOB" description:"job from --config used by queue administration commands"`
+
 		DBFile      string `long:"db-file" env:"DB_FILE" default:"./wddl.db" description:"database file"`
 		Threads     int    `long:"threads" env:"THREADS" default:"4" description:"parallel downloads"`
+		FileThreads int    `long:"file-threads" env:"FILE_THREADS" default:"2" description:"parallel partition downloads per file"`
+		PartSize    int64  `long:"partition-size" env:"P_SIZE" default:"64" description:"partition size (MB)"`
 		Timeout     int    `long:"timeout" env:"TIMEOUT" default:"600" description:"rescan timeout (seconds)"`
 		ClearRemote bool   `long:"clear-remote" env:"CLEAR_REMOTE" description:"clear remote files"`
+		Once        bool   `long:"once" env:"ONCE" description:"scan, download everything and exit (non-zero exit code on failures)"`
+		DryRun      bool   `long:"dry-run" env:"DRY_RUN" description:"print what would be downloaded, skipped and deleted, then exit"`
+		PlanFormat  string `long:"plan-format" env:"PLAN_FORMAT" default:"table" choice:"table" choice:"json" description:"dry-run output format"`
+
+		MaxFailures  int `long:"max-failures" env:"MAX_FAILURES" default:"5" description:"failed download cycles before file is moved to dead list"`
+		FailureDelay int `long:"failure-delay" env:"FAILURE_DELAY" default:"60" description:"delay before retrying failed file (seconds)"`
+		Lease        int `long:"lease" env:"LEASE" default:"600" description:"time after which file held by a stuck worker is re-issued (seconds)"`
+
+		Verify bool `long:"verify" env:"VERIFY" description:"verify checksums provided by the server after download"`
+
+		SettleScans int `long:"settle-scans" env:"SETTLE_SCANS" default:"0" description:"scans with unchanged size and mtime before file is queued"`
+		SettleAge   int `long:"settle-age" env:"SETTLE_AGE" default:"0" description:"queue files unchanged for this long regardless of scans (seconds)"`
+
+		Order       string   `long:"order" env:"ORDER" default:"none" choice:"none" choice:"smallest" choice:"largest" choice:"oldest" choice:"path" description:"queue dispatch order"`
+		Priority    []string `long:"priority" env:"PRIORITY" env-delim:"," description:"priority of files under path, e.g. /urgent=10"`
+		HistoryMode string   `long:"history-mode" env:"HISTORY_MODE" default:"mirror" choice:"mirror" choice:"inbox" description:"mirror re-fetches files missing locally, inbox never re-fetches delivered files"`
 
 		WebDav struct {
-			Server   string `long:"server" env:"SERVER" default:"https://dav.yandex.ru" description:"webdav server"`
-			User     string `long:"user" env:"USER" default:"guest" description:"webdav user"`
-			Password string `long:"password" env:"PASSWORD" description:"webdav password"`
+			Server   string `long:"server" env:"SERVER" default:"https://dav.yandex.ru" description:"source url: http(s)://, webdav://, davs://, sftp://, ftp://, s3://bucket or file:///mnt/share"`
+			User     string `long:"user" env:"USER" default:"guest" description:"source user (if not set in url)"`
+			Password string `long:"password" env:"PASSWORD" description:"source password (if not set in url)"`
+			ETagMD5  bool   `long:"etag-md5" env:"ETAG_MD5" description:"treat md5-like etag as content md5 (Yandex Disk)"`
 		} `group:"WebDav Сервер" namespace:"webdav" env-namespace:"WEBDAV"`
 
+		Scan struct {
+			Threads     int  `long:"threads" env:"THREADS" default:"4" description:"directories listed concurrently during scan"`
+			MaxDepth    int  `long:"max-depth" env:"MAX_DEPTH" default:"0" description:"maximum directory depth below input path (0 - unlimited)"`
+			Incremental bool `long:"incremental" env:"INCREMENTAL" description:"skip directories whose ctag/etag/mtime did not change since the previous scan (server must propagate changes to parent directories)"`
+			FullEvery   int  `long:"full-every" env:"FULL_EVERY" default:"24" description:"read all directories every N-th incremental scan (0 - never)"`
+		} `group:"Сканирование" namespace:"scan" env-namespace:"SCAN"`
+
+		Retry struct {
+			Attempts int     `long:"attempts" env:"ATTEMPTS" default:"3" description:"request attempts within one download cycle"`
+			Base     int     `long:"base" env:"BASE" default:"2" description:"delay before the first retry, doubled by each next one (seconds)"`
+			Cap      int     `long:"cap" env:"CAP" default:"60" description:"maximum delay between retries, also limits Retry-After requested by the server (seconds)"`
+			Jitter   float64 `long:"jitter" env:"JITTER" default:"0.2" description:"random deviation of retry delay (fraction of delay)"`
+		} `group:"Повторы запросов" namespace:"retry" env-namespace:"RETRY"`
+
+		Filter struct {
+			Include     []string `long:"include" env:"INCLUDE" env-delim:"," description:"download only files matching pattern (glob or re:regex)"`
+			Exclude     []string `long:"exclude" env:"EXCLUDE" env-delim:"," description:"skip files and directories matching pattern (glob or re:regex)"`
+			ExcludeDirs []string `long:"exclude-dir" env:"EXCLUDE_DIR" env-delim:"," description:"skip directories matching pattern"`
+			MinSize     int64    `long:"min-size" env:"MIN_SIZE" description:"skip files smaller than size (bytes)"`
+			MaxSize     int64    `long:"max-size" env:"MAX_SIZE" description:"skip files larger than size (bytes)"`
+		} `group:"Фильтры" namespace:"filter" env-namespace:"FILTER"`
+
+		Limit struct {
+			Rate     string   `long:"rate" env:"RATE" default:"0" description:"total download rate, e.g. 512K or 2M (0 - unlimited)"`
+			FileRate string   `long:"file-rate" env:"FILE_RATE" default:"0" description:"download rate of a single file (0 - unlimited)"`
+			Schedule []string `long:"schedule" env:"SCHEDULE" env-delim:"," description:"total rate by time of day, e.g. 09:00-18:00=2M"`
+		} `group:"Ограничение скорости" namespace:"limit" env-namespace:"LIMIT"`
+
+		Mirror struct {
+			Deletes    string `long:"deletes" env:"DELETES" default:"none" choice:"none" choice:"delete" choice:"trash" description:"what to do with local copies of files deleted remotely"`
+			Trash      string `long:"trash" env:"TRASH" default:"./trash" description:"directory for local copies in trash mode, jobs from --config use <trash>/<job>"`
+			MaxDeletes int    `long:"max-deletes" env:"MAX_DELETES" default:"100" description:"skip deletions if more files than this are gone in one scan (0 - unlimited)"`
+		} `group:"Зеркалирование удалений" namespace:"mirror" env-namespace:"MIRROR"`
+
+		Window struct {
+			Active []string `long:"active" env:"ACTIVE" env-delim:";" description:"download windows, e.g. 'mon-fri 19:00-08:00' or 'sat,sun' (empty - always)"`
+			Pause  bool     `long:"pause-in-flight" env:"PAUSE_IN_FLIGHT" description:"pause started downloads at partition boundary outside of window"`
+		} `group:"Окна загрузки" namespace:"window" env-namespace:"WINDOW"`
+
+		Upload struct {
+			Outbox      string `long:"outbox" env:"OUTBOX" description:"local directory uploaded to the server, disabled if empty"`
+			Remote      string `long:"remote" env:"REMOTE" default:"/outbox" description:"remote directory for uploaded files"`
+			DBFile      string `long:"db-file" env:"DB_FILE" default:"./upload.db" description:"upload queue database file"`
+			RemoveLocal bool   `long:"remove-local" env:"REMOVE_LOCAL" description:"delete local files after verified upload"`
+			Chunking    string `long:"chunking" env:"CHUNKING" default:"auto" choice:"auto" choice:"nextcloud" choice:"none" description:"chunked upload protocol, auto enables nextcloud for .../remote.php/dav/files/<user> servers"`
+			UploadsURL  string `long:"uploads-url" env:"UPLOADS_URL" description:"nextcloud chunked upload endpoint (default derived from server url)"`
+		} `group:"Выгрузка" namespace:"upload" env-namespace:"UPLOAD"`
+
+		API struct {
+			Listen string `long:"listen" env:"LISTEN" description:"http api and /metrics listen address, disabled if empty"`
+		} `group:"HTTP API" namespace:"api" env-namespace:"API"`
+
 		Util struct {
 			ClearRemote bool `long:"clear-remote" env:"CLEAR_REMOTE" description:"clear remote files"`
 		} `group:"Утилиты" namespace:"util" env-namespace:"UTIL"`
@@ -39,29 +121,111 @@ var (
 )
 
 func main() {
+	if isAdmin(os.Args[1:]) {
+		os.Exit(runAdmin(os.Args[1:]))
+	}
+
 	app := app.New("Webdav Downloader", revision, &opts)
 
+	if opts.Config != "" {
+		runJobs(app.Context(), app.Log())
+		app.GS(time.Second * 10)
+		return
+	}
+
 	{
-		config := engine.Config{
-			InputPath:    opts.Input,
-			OutputPath:   opts.Output,
-			TempPath:     opts.Temp,
-			Concurrency:  opts.Threads,
-			ScanEvery:    time.Second * time.Duration(opts.Timeout),
-			RemoveRemote: opts.ClearRemote,
+		config := engineConfig()
+
+		// Загруженные файлы удаляются из удаленного хранилища
+		// и были бы удалены локально при следующем сканировании
+		if config.RemoveRemote && config.MirrorDeletes != engine.DeleteModeNone {
+			app.Log().Logf("[ERROR] --clear-remote can not be combined with --mirror.deletes")
+			os.Exit(2)
 		}
 
-		wd := gowebdav.NewClient(opts.WebDav.Server, opts.WebDav.User, opts.WebDav.Password)
-		err := wd.Connect()
+		filter, err := filter.New(filter.Options{
+
+			Include:     opts.Filter.Include,
+			Exclude:     opts.Filter.Exclude,
+			ExcludeDirs: opts.Filter.ExcludeDirs,
+			MinSize:     opts.Filter.MinSize,
+			MaxSize:     opts.Filter.MaxSize,
+		})
+		if err != nil {
+			app.Log().Logf("[ERROR] filter error: %v", err)
+			os.Exit(2)
+		}
+
+		config.Filter = filter
+
+		config.Priorities, err = engine.ParsePriorityRules(opts.Priority)
+		if err != nil {
+			app.Log().Logf("[ERROR] priority error: %v", err)
+			os.Exit(2)
+		}
+
+		schedule, err := limiter.ParseSchedule(opts.Limit.Rate, opts.Limit.Schedule)
+		if err != nil {
+			app.Log().Logf("[ERROR] rate limit error: %v", err)
+			os.Exit(2)
+		}
+
+		fileRate, err := limiter.ParseRate(opts.Limit.FileRate)
+		if err != nil {
+			app.Log().Logf("[ERROR] rate limit error: %v", err)
+			os.Exit(2)
+		}
+
+		bandwidth := limiter.NewBandwidth(app.Log(), schedule, fileRate)
+		config.Throttle = bandwidth
+		config.Breaker = limiter.NewBreaker()
+
+		windows, err := window.Parse(opts.Window.Active)
+		if err != nil {
+			app.Log().Logf("[ERROR] download window error: %v", err)
+			os.Exit(2)
+		}
+
+		config.Window = windows
+
+		src, err := source.Open(opts.WebDav.Server, source.Options{
+			User:     opts.WebDav.User,
+			Password: opts.WebDav.Password,
+			ETagMD5:  opts.WebDav.ETagMD5,
+		})
 		if err != nil {
-			app.Log().Logf("[ERROR] webdav error: %v", err)
+			app.Log().Logf("[ERROR] source error: %v", err)
 			os.Exit(2)
 		}
 
 		targetAction := targetAction()
 		switch targetAction {
+		case ActionDryRun:
+			plan, err := dryRun(app.Context(), app.Log(), src, config)
+			if err != nil {
+				app.Log().Logf("[ERROR] dry run error: %v", err)
+				os.Exit(2)
+			}
+
+			if opts.PlanFormat == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(plan)
+			} else {
+				err = plan.WriteTable(os.Stdout)
+			}
+
+			if err != nil {
+				app.Log().Logf("[ERROR] dry run output error: %v", err)
+				os.Exit(2)
+			}
+
+			os.Exit(0)
 		case ActionClearRemote:
-			utils := utils.New(wd, opts.Output, opts.Input)
+			utils := utils.New(src, opts.Output, opts.Input)
+			utils.Threads = opts.Scan.Threads
+			utils.MaxDepth = opts.Scan.MaxDepth
+
 			err := utils.ClearRemoteFiles()
 			if err != nil {
 				app.Log().Logf("[ERROR] clear remote files error: %v", err)
@@ -70,33 +234,153 @@ func main() {
 
 			os.Exit(0)
 		default:
+			var uploader *engine.Engine
+			if opts.Upload.Outbox != "" {
+				uploader, err = uploadEngine(app.Log(), config)
+				if err != nil {
+					app.Log().Logf("[ERROR] upload error: %v", err)
+					os.Exit(2)
+				}
+			}
+
 			queue, err := queue.New(opts.DBFile)
 			if err != nil {
 				app.Log().Logf("[ERROR] queue error: %v", err)
 				os.Exit(2)
 			}
 
-			files := files.New(wd)
+			if opts.Scan.Incremental {
+				config.ScanCache = queue
+			}
+
+			files := files.New(src)
+
+			engine := engine.New(app.Log(), config, files, files, queue, queue)
+			go bandwidth.Run(app.Context())
+
+			if opts.API.Listen != "" {
+				server := server.New(app.Log(), engine, queue, bandwidth)
+				server.Start(app.Context(), opts.API.Listen)
+			}
+
+			if targetAction == ActionOnce {
+				summary, err := engine.Once(app.Context())
+				if err != nil {
+					app.Log().Logf("[ERROR] sync error: %v", err)
+					os.Exit(2)
+				}
+
+				fmt.Println(summary)
+				failed := summary.Failed + summary.ScanErrors
+
+				if uploader != nil {
+					summary, err = uploader.Once(app.Context())
+					if err != nil {
+						app.Log().Logf("[ERROR] upload error: %v", err)
+						os.Exit(2)
+					}
+
+					fmt.Println("upload", summary)
+					failed += summary.Failed + summary.ScanErrors
+				}
+
+				if failed > 0 {
+					os.Exit(1)
+				}
+
+				os.Exit(0)
+			}
 
-			engine := engine.New(app.Log(), config, files, files, queue)
 			engine.Start(app.Context())
+			if uploader != nil {
+				uploader.Start(app.Context())
+			}
 		}
 	}
 
 	app.GS(time.Second * 10)
 }
 
+// engineConfig - собирает конфигурацию движка из параметров запуска
+func engineConfig() engine.Config {
+	return engine.Config{
+		InputPath:        opts.Input,
+		OutputPath:       opts.Output,
+		TempPath:         opts.Temp,
+		Concurrency:      opts.Threads,
+		PartitionThreads: opts.FileThreads,
+		PartitionSize:    opts.PartSize << 20,
+		ScanEvery:        time.Second * time.Duration(opts.Timeout),
+		RemoveRemote:     opts.ClearRemote,
+		MaxFailures:      opts.MaxFailures,
+		FailureDelay:     time.Second * time.Duration(opts.FailureDelay),
+		Lease:            time.Second * time.Duration(opts.Lease),
+		HistoryMode:      engine.HistoryMode(opts.HistoryMode),
+		Order:            engine.Order(opts.Order),
+		VerifyChecksum:   opts.Verify,
+		SettleScans:      opts.SettleScans,
+		SettleAge:        time.Second * time.Duration(opts.SettleAge),
+		MirrorDeletes:    engine.DeleteMode(opts.Mirror.Deletes),
+		TrashPath:        opts.Mirror.Trash,
+		MaxDeletes:       opts.Mirror.MaxDeletes,
+		ScanThreads:      opts.Scan.Threads,
+		MaxDepth:         opts.Scan.MaxDepth,
+		FullScanEvery:    opts.Scan.FullEvery,
+		PauseInFlight:    opts.Window.Pause,
+		Retry: engine.RetryPolicy{
+			MaxAttempts: opts.Retry.Attempts,
+			Base:        time.Second * time.Duration(opts.Retry.Base),
+			Cap:         time.Second * time.Duration(opts.Retry.Cap),
+			Jitter:      opts.Retry.Jitter,
+		},
+	}
+}
+
 type Action string
 
 const (
 	ActionNone        Action = "none"
+	ActionDryRun      Action = "dry-run"
 	ActionClearRemote Action = "clear-remote"
+	ActionOnce        Action = "once"
 )
 
 func targetAction() Action {
+	// Пробный запуск учитывает остальные действия, но не выполняет их
+	if opts.DryRun {
+		return ActionDryRun
+	}
+
 	if opts.Util.ClearRemote {
 		return ActionClearRemote
 	}
 
+	if opts.Once {
+		return ActionOnce
+	}
+
 	return ActionNone
 }
+
+// dryRun - строит план действия, которое выполнил бы запуск без --dry-run
+//
+// С --util.clear-remote запускается только очистка удаленного хранилища,
+// поэтому план содержит удаляемые ей файлы, а не загрузки
+func dryRun(ctx context.Context, log lgr.L, src source.Source, config engine.Config) (*engine.Plan, error) {
+	if opts.Util.ClearRemote {
+		utils := utils.New(src, opts.Output, opts.Input)
+		utils.Threads = opts.Scan.Threads
+		utils.MaxDepth = opts.Scan.MaxDepth
+
+		return utils.Plan()
+	}
+
+	queue, err := queue.NewReadOnly(opts.DBFile, "")
+	if err != nil {
+		return nil, fmt.Errorf("queue error: %w", err)
+	}
+	defer queue.Close()
+
+	files := files.New(src)
+	return engine.New(log, config, files, files, queue, queue).Plan(ctx)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ReanSn0w/wddl/pkg/dav"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/ReanSn0w/wddl/pkg/source"
+	"github.com/ReanSn0w/wddl/pkg/upload"
+	"github.com/go-pkgz/lgr"
+	"github.com/studio-b12/gowebdav"
+)
+
+// uploadEngine - создает движок выгрузки локальной директории --upload.outbox
+//
+// Выгрузка использует отдельную очередь, общие с загрузкой параметры
+// потоков, повторов, окон и ограничения скорости, но не фильтры,
+// приоритеты и зеркалирование удалений
+func uploadEngine(log lgr.L, base engine.Config) (*engine.Engine, error) {
+	server, ok := source.WebdavURL(opts.WebDav.Server)
+	if !ok {
+		return nil, errors.New("upload is supported only for webdav servers")
+	}
+
+	// Клиенты сервера и адреса загрузок разделяют согласованную авторизацию
+	auth := gowebdav.NewAutoAuth(opts.WebDav.User, opts.WebDav.Password)
+
+	client := dav.New(server, auth, opts.WebDav.ETagMD5)
+	err := client.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("webdav: %w", err)
+	}
+
+	var uploads upload.Webdav
+	if opts.Upload.Chunking != "none" {
+		endpoint := opts.Upload.UploadsURL
+		if endpoint == "" {
+			endpoint, _ = upload.NextcloudUploads(server)
+		}
+
+		if endpoint == "" && opts.Upload.Chunking == "nextcloud" {
+			return nil, errors.New("can not derive nextcloud uploads url from server url, set --upload.uploads-url")
+		}
+
+		if endpoint != "" {
+			err = upload.CheckChunkSize(base.PartitionSize)
+			if err != nil {
+				return nil, fmt.Errorf("--partition-size: %w", err)
+			}
+
+			uploads = dav.New(endpoint, auth, false)
+		}
+	}
+
+	uploader := upload.New(client, uploads)
+
+	config := base
+	config.InputPath = filepath.Clean(opts.Upload.Outbox)
+	config.OutputPath = strings.TrimSuffix("/"+strings.Trim(opts.Upload.Remote, "/"), "/")
+	config.RemoveRemote = opts.Upload.RemoveLocal
+	config.Filter = nil
+	config.Priorities = nil
+	config.MirrorDeletes = engine.DeleteModeNone
+	config.ScanCache = nil
+	config.Target = uploader
+
+	q, err := queue.New(opts.Upload.DBFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return engine.New(log, config, uploader, uploader, q, q), nil
+}
@@ -0,0 +1,555 @@
+package dav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/retry"
+	"github.com/studio-b12/gowebdav"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:cs="http://calendarserver.org/ns/" xmlns:y="urn:yandex:disk:meta">
+	<d:prop>
+		<d:displayname/>
+		<d:resourcetype/>
+		<d:getcontentlength/>
+		<d:getlastmodified/>
+		<d:getetag/>
+		<cs:getctag/>
+		<oc:checksums/>
+		<y:md5/>
+		<y:sha256/>
+	</d:prop>
+</d:propfind>`
+
+// New - создает клиент WebDAV сервера server
+//
+// Запросы клиента и встроенного gowebdav.Client авторизуются общим
+// auth (например, gowebdav.NewAutoAuth согласует Basic или Digest
+// по ответу сервера), поэтому клиенты одного сервера могут разделять
+// согласованную авторизацию
+func New(server string, auth gowebdav.Authorizer, etagMD5 bool) *Client {
+	return &Client{
+		Client:  gowebdav.NewAuthClient(server, auth),
+		server:  server,
+		auth:    auth,
+		etagMD5: etagMD5,
+		http: &http.Client{
+			CheckRedirect: checkRedirect,
+		},
+	}
+}
+
+// Client - WebDAV клиент, дополняющий gowebdav получением
+// расширенных свойств файлов (контрольные суммы, ctag) через PROPFIND
+type Client struct {
+	*gowebdav.Client
+
+	server  string
+	auth    gowebdav.Authorizer
+	etagMD5 bool
+	http    *http.Client
+}
+
+// SetTransport - задает транспорт запросов клиента и gowebdav.Client
+func (c *Client) SetTransport(transport http.RoundTripper) {
+	c.Client.SetTransport(transport)
+	c.http.Transport = transport
+}
+
+// SetTimeout - ограничивает время запросов клиента и gowebdav.Client
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.Client.SetTimeout(timeout)
+	c.http.Timeout = timeout
+}
+
+// ReadDir - возвращает содержимое директории
+//
+// Элементы результата имеют тип *FileInfo
+func (c *Client) ReadDir(dir string) ([]os.FileInfo, error) {
+	items, err := c.Propfind(dir, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]os.FileInfo, 0, len(items))
+	for _, item := range items {
+		if item.self {
+			continue
+		}
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// ReadTree - возвращает содержимое директории и всех вложенных
+// директорий одним запросом PROPFIND с Depth: infinity
+//
+// Ключи результата - пути директорий относительно корня сервера
+// в виде path.Clean. Серверы, запрещающие такие запросы, отвечают 403
+func (c *Client) ReadTree(dir string) (map[string][]os.FileInfo, error) {
+	items, err := c.Propfind(dir, "infinity")
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := c.url("")
+	if err != nil {
+		return nil, err
+	}
+
+	root := path.Clean("/" + dir)
+	result := map[string][]os.FileInfo{root: {}}
+
+	for _, item := range items {
+		if item.self {
+			continue
+		}
+
+		name := path.Clean("/" + strings.TrimPrefix(item.path, base.Path))
+		if !strings.HasPrefix(name, strings.TrimSuffix(root, "/")+"/") {
+			continue
+		}
+
+		parent := path.Dir(name)
+		result[parent] = append(result[parent], item)
+
+		// Пустые директории тоже должны попасть в результат
+		if item.isDir && result[name] == nil {
+			result[name] = []os.FileInfo{}
+		}
+	}
+
+	return result, nil
+}
+
+// Propfind - выполняет PROPFIND запрос с указанной глубиной
+// и возвращает все элементы ответа, включая запрошенную директорию
+func (c *Client) Propfind(dir string, depth string) ([]*FileInfo, error) {
+	target, err := c.url(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(target.Path, "/") {
+		target.Path += "/"
+	}
+
+	return c.propfind(target, dir, depth)
+}
+
+// Stat - возвращает свойства файла или директории
+//
+// Результат имеет тип *FileInfo
+func (c *Client) Stat(name string) (os.FileInfo, error) {
+	target, err := c.url(name)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := c.propfind(target, name, "0")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if item.self {
+			return item, nil
+		}
+	}
+
+	return nil, gowebdav.NewPathError("Stat", name, http.StatusNotFound)
+}
+
+// Do - выполняет запрос к файлу на сервере с авторизацией клиента
+//
+// size - длина тела запроса, -1 если неизвестна. Отмена ctx прерывает запрос
+func (c *Client) Do(ctx context.Context, method, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	target, err := c.url(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.send(ctx, method, target, name, body, size, header)
+}
+
+// ReadStreamRange - возвращает поток length байт файла, начиная с offset
+//
+// В отличие от gowebdav, ошибка отказа сервера содержит задержку
+// из заголовка Retry-After (см. retry.After)
+func (c *Client) ReadStreamRange(name string, offset int64, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.Do(context.Background(), http.MethodGet, name, nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// Сервер не поддерживает Range и отдает файл целиком
+		_, err = io.CopyN(io.Discard, resp.Body, offset)
+		if err != nil {
+			resp.Body.Close()
+			return nil, &os.PathError{Op: "ReadStreamRange", Path: name, Err: err}
+		}
+
+		if length <= 0 {
+			return resp.Body, nil
+		}
+
+		return &limitedBody{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+	default:
+		resp.Body.Close()
+		return nil, retry.WithAfter(gowebdav.NewPathError("ReadStreamRange", name, resp.StatusCode), resp)
+	}
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// URL - возвращает абсолютный адрес файла на сервере
+func (c *Client) URL(name string) string {
+	target, err := c.url(name)
+	if err != nil {
+		return c.server + name
+	}
+
+	return target.String()
+}
+
+func (c *Client) propfind(target *url.URL, name string, depth string) ([]*FileInfo, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	body := strings.NewReader(propfindBody)
+
+	resp, err := c.send(context.Background(), "PROPFIND", target, name, body, body.Size(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, retry.WithAfter(gowebdav.NewPathError("Propfind", name, resp.StatusCode), resp)
+	}
+
+	var ms multistatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		return nil, &os.PathError{Op: "Propfind", Path: name, Err: err}
+	}
+
+	result := make([]*FileInfo, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		info, err := c.fileInfo(target.Path, r)
+		if err != nil {
+			return nil, &os.PathError{Op: "Propfind", Path: name, Err: err}
+		}
+
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+// send - выполняет запрос, авторизуя его так же, как gowebdav
+//
+// Согласование авторизации повторяет запрос. Тело без io.Seeker
+// не буферизуется (загружаемые файлы велики), поэтому запрос с таким
+// телом завершается ошибкой, если авторизация еще не согласована
+// (см. Connect)
+func (c *Client) send(ctx context.Context, method string, target *url.URL, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	auth, _ := c.auth.NewAuthenticator(nil)
+	defer auth.Close()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		// Пустое тело с нулевой длиной иначе отправляется как chunked
+		switch {
+		case body != nil && size == 0:
+			req.Body = http.NoBody
+			req.ContentLength = 0
+		case body != nil && size > 0:
+			req.ContentLength = size
+		}
+
+		err = auth.Authorize(c.http, req, name)
+		if err != nil {
+			return nil, &os.PathError{Op: method, Path: name, Err: err}
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, &os.PathError{Op: method, Path: name, Err: err}
+		}
+
+		redo, err := auth.Verify(c.http, resp, name)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		if !redo {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+
+		err = rewind(body)
+		if err != nil {
+			return nil, &os.PathError{Op: method, Path: name, Err: err}
+		}
+	}
+}
+
+// rewind - возвращает тело запроса к началу для повтора
+func rewind(body io.Reader) error {
+	if body == nil {
+		return nil
+	}
+
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return errors.New("authorization is not negotiated, request body can not be resent")
+	}
+
+	_, err := seeker.Seek(0, io.SeekStart)
+	return err
+}
+
+// checkRedirect - следует перенаправлениям, как gowebdav: не более 10,
+// и не следует, если авторизация запросила их обработку сама
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return gowebdav.ErrTooManyRedirects
+	}
+
+	if via[0].Header.Get(gowebdav.XInhibitRedirect) != "" {
+		return http.ErrUseLastResponse
+	}
+
+	return nil
+}
+
+// url - возвращает адрес элемента на сервере без завершающей косой черты
+func (c *Client) url(name string) (*url.URL, error) {
+	base, err := url.Parse(c.server)
+	if err != nil {
+		return nil, err
+	}
+
+	base.Path = path.Join("/", base.Path, name)
+	return base, nil
+}
+
+func (c *Client) fileInfo(requested string, r response) (*FileInfo, error) {
+	href, err := url.Parse(r.Href)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FileInfo{
+		path: strings.TrimSuffix(href.Path, "/"),
+		self: strings.TrimSuffix(href.Path, "/") == strings.TrimSuffix(requested, "/"),
+	}
+
+	info.name = path.Base(info.path)
+
+	for _, ps := range r.Propstats {
+		if !strings.Contains(ps.Status, "200") {
+			continue
+		}
+
+		p := ps.Prop
+		info.isDir = info.isDir || p.ResourceType.Collection != nil
+
+		if p.ContentLength != "" {
+			info.size, _ = strconv.ParseInt(strings.TrimSpace(p.ContentLength), 10, 64)
+		}
+
+		if p.LastModified != "" {
+			info.modTime, _ = http.ParseTime(p.LastModified)
+		}
+
+		if p.ETag != "" {
+			info.etag = strings.Trim(p.ETag, `"`)
+		}
+
+		if p.CTag != "" {
+			info.ctag = p.CTag
+		}
+
+		info.checksums = mergeChecksums(info.checksums, p)
+	}
+
+	if c.etagMD5 && isMD5(info.etag) && info.checksums["md5"] == "" {
+		info.checksums = mergeChecksums(info.checksums, prop{MD5: info.etag})
+	}
+
+	return info, nil
+}
+
+// FileInfo - свойства файла, полученные через PROPFIND
+type FileInfo struct {
+	path      string
+	name      string
+	size      int64
+	modTime   time.Time
+	isDir     bool
+	self      bool
+	etag      string
+	ctag      string
+	checksums map[string]string
+}
+
+func (f *FileInfo) Name() string       { return f.name }
+func (f *FileInfo) Size() int64        { return f.size }
+func (f *FileInfo) ModTime() time.Time { return f.modTime }
+func (f *FileInfo) IsDir() bool        { return f.isDir }
+func (f *FileInfo) Sys() any           { return nil }
+
+func (f *FileInfo) String() string {
+	return fmt.Sprintf("%s (%d bytes)", f.path, f.size)
+}
+
+func (f *FileInfo) Mode() os.FileMode {
+	if f.isDir {
+		return 0775 | os.ModeDir
+	}
+
+	return 0664
+}
+
+// Path - путь элемента на сервере
+func (f *FileInfo) Path() string {
+	return f.path
+}
+
+// ETag - значение getetag
+func (f *FileInfo) ETag() string {
+	return f.etag
+}
+
+// CTag - значение getctag (для директорий, если сервер его поддерживает)
+func (f *FileInfo) CTag() string {
+	return f.ctag
+}
+
+// Checksum - возвращает наиболее надежную из известных контрольных
+// сумм файла в формате "алгоритм:значение" или пустую строку
+func (f *FileInfo) Checksum() string {
+	for _, algo := range []string{"sha256", "sha1", "md5"} {
+		if value := f.checksums[algo]; value != "" {
+			return algo + ":" + value
+		}
+	}
+
+	return ""
+}
+
+func mergeChecksums(result map[string]string, p prop) map[string]string {
+	add := func(algo, value string) {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			return
+		}
+
+		if result == nil {
+			result = make(map[string]string)
+		}
+
+		result[algo] = value
+	}
+
+	// Nextcloud / ownCloud: "SHA1:... MD5:... ADLER32:..."
+	for _, checksum := range p.Checksums.Checksum {
+		for _, item := range strings.Fields(checksum) {
+			algo, value, ok := strings.Cut(item, ":")
+			if ok {
+				add(strings.ToLower(algo), value)
+			}
+		}
+	}
+
+	add("md5", p.MD5)
+	add("sha256", p.SHA256)
+
+	return result
+}
+
+func isMD5(value string) bool {
+	if len(value) != 32 {
+		return false
+	}
+
+	for _, c := range value {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type multistatus struct {
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   prop   `xml:"DAV: prop"`
+}
+
+type prop struct {
+	DisplayName  string `xml:"DAV: displayname"`
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+	CTag          string `xml:"getctag"`
+	Checksums     struct {
+		Checksum []string `xml:"checksum"`
+	} `xml:"checksums"`
+	MD5    string `xml:"md5"`
+	SHA256 string `xml:"sha256"`
+}
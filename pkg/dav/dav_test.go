@@ -0,0 +1,242 @@
+package dav_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/dav"
+	"github.com/ReanSn0w/wddl/pkg/retry"
+	"github.com/studio-b12/gowebdav"
+)
+
+const multistatus = `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:y="urn:yandex:disk:meta">
+	<d:response>
+		<d:href>/dav/data/</d:href>
+		<d:propstat>
+			<d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop>
+			<d:status>HTTP/1.1 200 OK</d:status>
+		</d:propstat>
+	</d:response>
+	<d:response>
+		<d:href>/dav/data/movie%20one.mkv</d:href>
+		<d:propstat>
+			<d:prop>
+				<d:resourcetype/>
+				<d:getcontentlength>1024</d:getcontentlength>
+				<d:getlastmodified>Mon, 02 Jan 2006 15:04:05 GMT</d:getlastmodified>
+				<d:getetag>"d41d8cd98f00b204e9800998ecf8427e"</d:getetag>
+				<oc:checksums><oc:checksum>SHA1:ABCDEF MD5:123456</oc:checksum></oc:checksums>
+			</d:prop>
+			<d:status>HTTP/1.1 200 OK</d:status>
+		</d:propstat>
+		<d:propstat>
+			<d:prop><y:sha256/></d:prop>
+			<d:status>HTTP/1.1 404 Not Found</d:status>
+		</d:propstat>
+	</d:response>
+	<d:response>
+		<d:href>/dav/data/yandex.bin</d:href>
+		<d:propstat>
+			<d:prop>
+				<d:getcontentlength>10</d:getcontentlength>
+				<d:getetag>"d41d8cd98f00b204e9800998ecf8427e"</d:getetag>
+			</d:prop>
+			<d:status>HTTP/1.1 200 OK</d:status>
+		</d:propstat>
+	</d:response>
+	<d:response>
+		<d:href>/dav/data/sub/</d:href>
+		<d:propstat>
+			<d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop>
+			<d:status>HTTP/1.1 200 OK</d:status>
+		</d:propstat>
+	</d:response>
+</d:multistatus>`
+
+func TestReadDir(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.URL.Path != "/dav/data/" || r.Header.Get("Depth") != "1" {
+			t.Errorf("unexpected request %s %s depth %s", r.Method, r.URL.Path, r.Header.Get("Depth"))
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(multistatus))
+	}))
+	defer srv.Close()
+
+	client := dav.New(srv.URL+"/dav", gowebdav.NewAutoAuth("", ""), true)
+
+	items, err := client.ReadDir("/data")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+
+	if len(items) != 3 {
+		t.Fatalf("ReadDir() returned %d items, want 3", len(items))
+	}
+
+	movie := items[0].(*dav.FileInfo)
+	if movie.Name() != "movie one.mkv" || movie.Size() != 1024 || movie.IsDir() || movie.ModTime().IsZero() {
+		t.Errorf("unexpected file info %v", movie)
+	}
+	if got := movie.Checksum(); got != "sha1:abcdef" {
+		t.Errorf("Checksum() = %q, want sha1:abcdef", got)
+	}
+
+	yandex := items[1].(*dav.FileInfo)
+	if got := yandex.Checksum(); got != "md5:d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("Checksum() = %q, want etag md5", got)
+	}
+
+	if !items[2].IsDir() || items[2].Name() != "sub" {
+		t.Errorf("unexpected directory info %v", items[2])
+	}
+}
+
+func TestStat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "0" {
+			t.Errorf("unexpected request %s %s depth %s", r.Method, r.URL.Path, r.Header.Get("Depth"))
+		}
+
+		if r.URL.Path != "/dav/data/movie one.mkv" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(multistatus))
+	}))
+	defer srv.Close()
+
+	client := dav.New(srv.URL+"/dav", gowebdav.NewAutoAuth("", ""), false)
+
+	info, err := client.Stat("/data/movie one.mkv")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	if info.Name() != "movie one.mkv" || info.Size() != 1024 {
+		t.Errorf("unexpected file info %v", info)
+	}
+
+	_, err = client.Stat("/data/missing.mkv")
+	if !gowebdav.IsErrNotFound(err) {
+		t.Errorf("Stat() of missing file error = %v, want not found", err)
+	}
+}
+
+func TestReadTree(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "infinity" {
+			t.Errorf("unexpected request %s %s depth %s", r.Method, r.URL.Path, r.Header.Get("Depth"))
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(multistatus))
+	}))
+	defer srv.Close()
+
+	client := dav.New(srv.URL+"/dav", gowebdav.NewAutoAuth("", ""), false)
+
+	tree, err := client.ReadTree("/data")
+	if err != nil {
+		t.Fatalf("ReadTree() error = %v", err)
+	}
+
+	if len(tree) != 2 || len(tree["/data"]) != 3 {
+		t.Fatalf("ReadTree() = %v, want /data with 3 items and empty /data/sub", tree)
+	}
+
+	if items, ok := tree["/data/sub"]; !ok || len(items) != 0 {
+		t.Errorf("ReadTree() /data/sub = %v, %v", items, ok)
+	}
+}
+
+func TestReadStreamRange(t *testing.T) {
+	data := "0123456789"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dav/ranged.bin":
+			http.ServeContent(w, r, "ranged.bin", time.Time{}, strings.NewReader(data))
+		case "/dav/plain.bin":
+			// Сервер без поддержки Range
+			w.Write([]byte(data))
+		default:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	client := dav.New(srv.URL+"/dav", gowebdav.NewAutoAuth("", ""), false)
+
+	for _, name := range []string{"/ranged.bin", "/plain.bin"} {
+		stream, err := client.ReadStreamRange(name, 3, 4)
+		if err != nil {
+			t.Fatalf("ReadStreamRange(%s) error = %v", name, err)
+		}
+
+		got, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil || string(got) != "3456" {
+			t.Errorf("ReadStreamRange(%s) = %q, %v, want 3456", name, got, err)
+		}
+	}
+
+	_, err := client.ReadStreamRange("/throttled.bin", 0, 4)
+	if retry.Classify(err) != retry.ClassThrottled || retry.After(err) != 7*time.Second {
+		t.Errorf("ReadStreamRange() error = %v, want throttled with retry after 7s", err)
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") || !strings.Contains(auth, `username="user"`) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="dav", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(multistatus))
+		default:
+			http.ServeContent(w, r, "movie.mkv", time.Time{}, strings.NewReader("0123456789"))
+		}
+	}))
+	defer srv.Close()
+
+	client := dav.New(srv.URL+"/dav", gowebdav.NewAutoAuth("user", "secret"), false)
+
+	items, err := client.ReadDir("/data")
+	if err != nil || len(items) != 3 {
+		t.Fatalf("ReadDir() = %d items, %v, want 3 items", len(items), err)
+	}
+
+	stream, err := client.ReadStreamRange("/data/movie one.mkv", 2, 3)
+	if err != nil {
+		t.Fatalf("ReadStreamRange() error = %v", err)
+	}
+
+	got, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil || string(got) != "234" {
+		t.Errorf("ReadStreamRange() = %q, %v, want 234", got, err)
+	}
+
+	denied := dav.New(srv.URL+"/dav", gowebdav.NewAutoAuth("guest", "secret"), false)
+
+	_, err = denied.ReadDir("/data")
+	if retry.Classify(err) != retry.ClassAuth {
+		t.Errorf("ReadDir() with wrong user error = %v, want auth error", err)
+	}
+}
@@ -0,0 +1,343 @@
+// Package davtest предоставляет WebDAV сервер в памяти процесса
+// для интеграционных тестов с возможностью внедрения сбоев
+package davtest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/dav"
+	"github.com/studio-b12/gowebdav"
+	"golang.org/x/net/webdav"
+)
+
+// Тип внедряемого сбоя
+type FaultKind int
+
+const (
+	// Ответ с кодом Status (и заголовком Retry-After, если он задан)
+	FaultStatus FaultKind = iota
+
+	// Задержка Delay перед обработкой запроса
+	FaultDelay
+
+	// Обрыв соединения после отправки Bytes байт тела ответа
+	FaultDrop
+
+	// Ответ, усеченный до Bytes байт, без заголовка Content-Length
+	FaultTruncate
+)
+
+// Fault - описание сбоя, который сервер внедрит в ответ на запрос
+type Fault struct {
+	Kind FaultKind
+
+	// Метод запроса ("" - любой)
+	Method string
+
+	// Префикс пути запроса ("" - любой)
+	Path string
+
+	// Количество срабатываний (0 - одно)
+	Times int
+
+	Status     int
+	RetryAfter time.Duration
+	Delay      time.Duration
+	Bytes      int64
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+
+	return strings.HasPrefix(r.URL.Path, f.Path)
+}
+
+// NewServer - запускает WebDAV сервер с файловой системой в памяти
+func NewServer() *Server {
+	s := &Server{
+		fs:       webdav.NewMemFS(),
+		requests: make(map[string]int),
+	}
+
+	s.handler = &webdav.Handler{
+		FileSystem: s.fs,
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.server.URL
+
+	return s
+}
+
+type Server struct {
+	URL string
+
+	fs      webdav.FileSystem
+	handler *webdav.Handler
+	server  *httptest.Server
+
+	mx       sync.Mutex
+	faults   []*Fault
+	requests map[string]int
+}
+
+// Close - останавливает сервер
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client - возвращает подключенный клиент для сервера
+//
+// Подключение выполняет согласование авторизации, иначе клиент
+// повторяет первый запрос и внедренный сбой поглощается им
+func (s *Server) Client() *dav.Client {
+	client := dav.New(s.URL, gowebdav.NewAutoAuth("", ""), false)
+	_ = client.Connect()
+	return client
+}
+
+// Inject - добавляет сбой, сбои срабатывают в порядке добавления
+func (s *Server) Inject(fault Fault) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if fault.Times <= 0 {
+		fault.Times = 1
+	}
+
+	s.faults = append(s.faults, &fault)
+}
+
+// Requests - возвращает количество запросов с указанным методом
+func (s *Server) Requests(method string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.requests[method]
+}
+
+// WriteFile - создает файл на сервере вместе с родительскими директориями
+func (s *Server) WriteFile(name string, data []byte) error {
+	ctx := context.Background()
+
+	err := s.mkdirAll(ctx, path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	f, err := s.fs.OpenFile(ctx, name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// ReadFile - возвращает содержимое файла на сервере
+func (s *Server) ReadFile(name string) ([]byte, error) {
+	f, err := s.fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Exists - проверяет наличие файла на сервере
+func (s *Server) Exists(name string) bool {
+	_, err := s.fs.Stat(context.Background(), name)
+	return err == nil
+}
+
+func (s *Server) mkdirAll(ctx context.Context, dir string) error {
+	current := "/"
+
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		current = path.Join(current, part)
+
+		err := s.fs.Mkdir(ctx, current, 0755)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	fault := s.take(r)
+	if fault == nil {
+		if r.Method == "MOVE" && path.Base(r.URL.Path) == ".file" {
+			s.assemble(w, r)
+			return
+		}
+
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+
+	switch fault.Kind {
+	case FaultStatus:
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter.Seconds())))
+		}
+
+		w.WriteHeader(fault.Status)
+	case FaultDelay:
+		time.Sleep(fault.Delay)
+		s.handler.ServeHTTP(w, r)
+	case FaultDrop:
+		s.handler.ServeHTTP(&limitedWriter{ResponseWriter: w, limit: fault.Bytes}, r)
+
+		// Прерывание обработчика закрывает соединение без завершения ответа
+		panic(http.ErrAbortHandler)
+	case FaultTruncate:
+		s.handler.ServeHTTP(&limitedWriter{ResponseWriter: w, limit: fault.Bytes, dropLength: true}, r)
+	}
+}
+
+// assemble - собирает файл из частей загрузки Nextcloud (chunking v2)
+//
+// Части хранятся в директории загрузки, из которой перемещается
+// виртуальный файл ".file", и объединяются в порядке имен
+func (s *Server) assemble(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dir := path.Dir(r.URL.Path)
+
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parent, err := s.fs.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	chunks, err := parent.Readdir(-1)
+	parent.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name() < chunks[j].Name() })
+
+	var data []byte
+	for _, chunk := range chunks {
+		part, err := s.ReadFile(path.Join(dir, chunk.Name()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data = append(data, part...)
+	}
+
+	if total := r.Header.Get("OC-Total-Length"); total != "" && total != strconv.Itoa(len(data)) {
+		http.Error(w, "total length mismatch", http.StatusBadRequest)
+		return
+	}
+
+	err = s.WriteFile(dest.Path, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.fs.RemoveAll(ctx, dir)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// take - учитывает запрос и возвращает сбой, который следует внедрить
+func (s *Server) take(r *http.Request) *Fault {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.requests[r.Method]++
+
+	for i, fault := range s.faults {
+		if !fault.matches(r) {
+			continue
+		}
+
+		fault.Times--
+		if fault.Times <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+
+		return fault
+	}
+
+	return nil
+}
+
+// limitedWriter - пропускает не более limit байт тела ответа
+type limitedWriter struct {
+	http.ResponseWriter
+
+	limit      int64
+	written    int64
+	dropLength bool
+}
+
+func (w *limitedWriter) WriteHeader(code int) {
+	if w.dropLength {
+		w.Header().Del("Content-Length")
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitedWriter) Write(data []byte) (int, error) {
+	size := len(data)
+
+	remaining := w.limit - w.written
+	if remaining <= 0 {
+		return size, nil
+	}
+
+	if int64(len(data)) > remaining {
+		data = data[:remaining]
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	w.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// Сообщаем обработчику о полной записи, чтобы он не прерывался сам
+	return size, nil
+}
@@ -0,0 +1,102 @@
+package engine
+
+import "time"
+
+// Report - сводка состояния движка
+type Report struct {
+	// Загрузка новых файлов приостановлена
+	Paused bool
+
+	// Текущее время входит в окно загрузки
+	InWindow bool
+
+	// Средняя скорость загрузки в байтах в секунду
+	Speed int64
+
+	// Оценка времени загрузки файлов из очереди
+	Estimate time.Duration
+
+	// Статистика очереди
+	Queue Stat
+
+	// Прогресс загружаемых в данный момент файлов
+	Active []Progress
+}
+
+// Pause - приостанавливает выдачу новых файлов на загрузку,
+// уже начатые загрузки продолжаются
+func (e *Engine) Pause() {
+	e.paused.Store(true)
+	e.log.Logf("[INFO] engine paused")
+}
+
+// Resume - возобновляет выдачу файлов на загрузку
+func (e *Engine) Resume() {
+	e.paused.Store(false)
+	e.log.Logf("[INFO] engine resumed")
+}
+
+// Rescan - запрашивает внеочередное сканирование удаленного хранилища
+func (e *Engine) Rescan() {
+	select {
+	case e.rescan <- struct{}{}:
+	default:
+		// Сканирование уже запрошено
+	}
+}
+
+// Active - возвращает прогресс загружаемых в данный момент файлов
+func (e *Engine) Active() []Progress {
+	e.activeMx.Lock()
+	defer e.activeMx.Unlock()
+
+	result := make([]Progress, 0, len(e.active))
+	for _, progress := range e.active {
+		result = append(result, progress)
+	}
+
+	return result
+}
+
+// Report - возвращает сводку состояния движка
+func (e *Engine) Report() (*Report, error) {
+	stat, err := e.queue.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	speed := e.speed.AvgSpeed()
+
+	return &Report{
+		Paused:   e.paused.Load(),
+		InWindow: e.config.InWindow(time.Now()),
+		Speed:    speed,
+		Estimate: stat.AvgTime(speed),
+		Queue:    *stat,
+		Active:   e.Active(),
+	}, nil
+}
+
+func (e *Engine) track(progress Progress) {
+	e.activeMx.Lock()
+	defer e.activeMx.Unlock()
+
+	e.active[progress.ID] = progress
+}
+
+// update - обновляет прогресс файла, если он еще загружается
+func (e *Engine) update(progress Progress) {
+	e.activeMx.Lock()
+	defer e.activeMx.Unlock()
+
+	if _, ok := e.active[progress.ID]; ok {
+		e.active[progress.ID] = progress
+	}
+}
+
+func (e *Engine) untrack(id string) {
+	e.activeMx.Lock()
+	defer e.activeMx.Unlock()
+
+	delete(e.active, id)
+}
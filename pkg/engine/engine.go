@@ -3,22 +3,30 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ReanSn0w/wddl/pkg/metrics"
 	"github.com/go-pkgz/lgr"
 )
 
-func New(log lgr.L, conf Config, scanner Scanner, downloader Downloader, queue Queue) *Engine {
+func New(log lgr.L, conf Config, scanner Scanner, downloader Downloader, queue Queue, history History) *Engine {
 	return &Engine{
 		log:        log,
 		config:     conf,
 		queue:      queue,
+		history:    history,
 		scanner:    scanner,
 		downloader: downloader,
-		fileLocks:  make(map[string]bool),
-		lockMutex:  &sync.Mutex{},
+		speed:      NewSpeedData(),
+		settle:     newSettler(),
+		outcomes:   newOutcomes(),
+		active:     make(map[string]Progress),
+		rescan:     make(chan struct{}, 1),
 	}
 }
 
@@ -26,14 +34,24 @@ type Engine struct {
 	log        lgr.L
 	config     Config
 	queue      Queue
+	history    History
 	scanner    Scanner
 	downloader Downloader
-	fileLocks  map[string]bool // Track locked files
-	lockMutex  *sync.Mutex     // Protect fileLocks map
+
+	speed    *SpeedData
+	settle   *settler
+	outcomes *outcomes
+	paused   atomic.Bool
+	outside  atomic.Bool // Текущее время вне окна загрузки
+	rescan   chan struct{}
+	activeMx sync.Mutex
+	active   map[string]Progress // Progress of files being downloaded
 }
 
 func (e *Engine) Start(ctx context.Context) {
 	progressCH := make(chan Progress, e.config.Concurrency)
+	// Воркеры нескольких движков процесса учитываются вместе
+	metrics.WorkersLimit.Add(float64(e.config.Concurrency))
 
 	// Запуск рутины для добавления новых файлов в очередь загрузки
 	go e.scanNewFiles(ctx, e.config.ScanEvery, e.config.InputPath)
@@ -50,131 +68,307 @@ func (e *Engine) scanNewFiles(ctx context.Context, duration time.Duration, input
 	ticker := time.NewTicker(duration)
 	e.log.Logf("[DEBUG] scan loop started")
 
+	// Первое сканирование не дожидается интервала
+	_ = e.scan(ctx, inputPath)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			e.log.Logf("[DEBUG] scan started")
+			_ = e.scan(ctx, inputPath)
+		case <-e.rescan:
+			e.log.Logf("[INFO] rescan requested")
+			_ = e.scan(ctx, inputPath)
+		default:
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+}
 
-			files, err := e.scanner.Scan(e.config, inputPath)
-			if err != nil {
-				e.log.Logf("[ERROR] failed to scan files: %v", err)
-				continue
-			}
+// Данный метод сканирует удаленное хранилище и добавляет новые файлы в очередь
+func (e *Engine) scan(ctx context.Context, inputPath string) error {
+	e.log.Logf("[DEBUG] scan started")
+	metrics.Scans.Inc()
 
-			e.log.Logf("[DEBUG] scanning completed: %d files found", len(files))
+	started := time.Now()
+	files, scanErr := e.scanner.Scan(ctx, e.config, inputPath)
+	metrics.ScanDuration.Set(time.Since(started).Seconds())
 
-			for _, file := range files {
-				stat, err := os.Stat(file.Dest)
-				if err != nil && !os.IsNotExist(err) {
-					e.log.Logf("[ERROR] failed to stat file %s: %v", file.Name, err)
-					continue
-				}
+	// Сканирование, прерванное остановкой, не обрабатывается
+	if ctx.Err() != nil {
+		e.log.Logf("[INFO] scan interrupted by shutdown")
+		return ctx.Err()
+	}
 
-				if stat != nil {
-					if stat.Size() == file.Size {
-						continue
-					}
-				}
+	// Файлы из прочитанных директорий обрабатываются и при частичном сканировании
+	var partial *ScanError
+	if errors.As(scanErr, &partial) {
+		metrics.ScanErrors.Inc()
+		for dir, err := range partial.Dirs {
+			e.log.Logf("[ERROR] failed to scan directory %s: %v", dir, err)
+		}
+	} else if scanErr != nil {
+		metrics.ScanErrors.Inc()
+		e.log.Logf("[ERROR] failed to scan files: %v", scanErr)
+		return scanErr
+	}
 
-				err = e.queue.Exists(file.ID)
-				switch err {
-				case nil:
-					e.log.Logf("[DEBUG] file %s already exists in queue", file.Name)
-				case ErrNotFound:
-					e.log.Logf("[DEBUG] file %s not found in queue", file.Name)
-					e.queue.Add(file)
-				default:
-					e.log.Logf("[ERROR] failed to check file %s in queue: %v", file.Name, err)
-				}
-			}
-		default:
-			time.Sleep(time.Millisecond * 100)
+	metrics.ScanFound.Set(float64(len(files)))
+	e.log.Logf("[DEBUG] scanning completed: %d files found", len(files))
+
+	e.settle.observe(files)
+	now := time.Now()
+
+	queued, err := e.queuedBySource()
+	if err != nil {
+		e.log.Logf("[ERROR] failed to list queue: %v", err)
+		return err
+	}
+
+	for _, file := range files {
+		action, err := e.decide(file, now)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to check file %s: %v", file.Name, err)
+			continue
+		}
+
+		switch action {
+		case ActionWait:
+			e.log.Logf("[DEBUG] file %s is still changing, waiting", file.Name)
+		case ActionQueued:
+			e.log.Logf("[DEBUG] file %s already exists in queue", file.Name)
+		case ActionDownload, ActionOverwrite:
+			e.log.Logf("[DEBUG] file %s not found in queue", file.Name)
+			e.dropSuperseded(file, queued[file.Source])
+			file.Priority = e.config.PriorityOf(file)
+			e.queue.Add(file)
 		}
 	}
+
+	e.mirrorDeletions(files, scanErr)
+	return scanErr
 }
 
 // Данный метод запускает воркеры загрузки файлов
+//
+// Каждый воркер запрашивает у очереди следующий файл только
+// после завершения предыдущего, поэтому файл выдается ровно
+// одному воркеру
 func (e *Engine) downloadFiles(ctx context.Context, pc chan<- Progress, limit int) {
-	ch := e.queue.Chan(ctx, e.log, func(f File) error {
-		stat, err := os.Stat(f.Dest)
-		if os.IsNotExist(err) {
-			return nil
-		}
+	host, _ := os.Hostname()
 
-		if f.Size != stat.Size() {
-			return nil
+	for i := range max(limit, 1) {
+		owner := fmt.Sprintf("%s:%d/%d", host, os.Getpid(), i)
+		go e.worker(ctx, pc, owner)
+	}
+}
+
+// worker - получает файлы из очереди и загружает их до завершения контекста
+func (e *Engine) worker(ctx context.Context, pc chan<- Progress, owner string) {
+	for e.waitDispatch(ctx) {
+		f, err := e.queue.Claim(ctx, owner, e.config.Order, e.config.LeaseTime())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			e.log.Logf("[ERROR] failed to claim file from queue: %v", err)
+			time.Sleep(time.Second)
+			continue
 		}
 
-		return err
-	})
+		// Выдача могла быть остановлена, пока воркер ожидал файл
+		if !e.dispatchAllowed() {
+			e.release(f)
+			continue
+		}
 
-	limiter := make(chan struct{}, limit)
+		e.process(ctx, pc, owner, f)
+	}
+}
 
-	for {
-		select {
-		case file := <-ch:
-			// Try to acquire file lock
-			if !e.acquireFileLock(file.ID) {
-				e.log.Logf("[WARN] file %s is already being downloaded, skipping", file.Name)
-				continue
+// process - загружает выданный воркеру файл
+//
+// При потере аренды загрузка прерывается, а результат не записывается,
+// так как файлом уже владеет другой исполнитель
+func (e *Engine) process(ctx context.Context, pc chan<- Progress, owner string, f File) {
+	fileCtx, lost := context.WithCancelCause(ctx)
+	defer lost(nil)
+
+	stop := e.keepLease(f, owner, lost)
+	defer stop()
+
+	// Аренда файла продлевается, пока воркер ожидает место в общем лимите
+	if e.config.Slots != nil {
+		err := e.config.Slots.Acquire(fileCtx)
+		if err != nil {
+			if !errors.Is(context.Cause(fileCtx), ErrLeaseLost) {
+				e.release(f)
 			}
 
-			limiter <- struct{}{}
+			return
+		}
+
+		defer e.config.Slots.Release()
+	}
 
-			go func(f File) {
-				defer func() {
-					<-limiter
-					e.releaseFileLock(f.ID)
-				}()
+	metrics.ActiveWorkers.Add(1)
 
-				err := e.filterTaskFromQueue(f.ID, f.Name, f.Dest, f.Size)
-				if err != nil {
+	defer func() {
+		metrics.ActiveWorkers.Add(-1)
+		e.untrack(f.ID)
+	}()
+
+	err := e.filterTaskFromQueue(owner, f)
+	if err != nil {
+		return
+	}
+
+	e.log.Logf("[DEBUG] starting download of file %s (size: %d bytes)", f.Name, f.Size)
+	e.track(Progress{ID: f.ID, Name: f.Name})
+
+	checksum, err := e.downloader.Download(fileCtx, e.config, pc, f)
+	if errors.Is(context.Cause(fileCtx), ErrLeaseLost) {
+		e.log.Logf("[WARN] lease of file %s lost, download result discarded", f.Name)
+		return
+	}
+
+	if ctx.Err() != nil {
+		e.log.Logf("[INFO] download of file %s interrupted by shutdown", f.Name)
+		return
+	}
+
+	if errors.Is(err, ErrPaused) {
+		e.log.Logf("[INFO] download of file %s paused until the next window", f.Name)
+		e.release(f)
+		return
+	}
+
+	if err != nil {
+		e.log.Logf("[ERROR] failed to download file %s: %v", f.Name, err)
+		e.failTask(f, err)
+		return
+	}
+
+	e.log.Logf("[INFO] successfully downloaded file %s", f.Name)
+	metrics.FilesCompleted.Inc()
+	e.outcomes.record(f, true)
+
+	err = e.queue.Done(f.ID, owner)
+	if err != nil {
+		e.log.Logf("[ERROR] failed to move file %s to the done list: %v", f.Name, err)
+	}
+
+	err = e.history.Remember(Record{
+		ID:          f.ID,
+		Source:      f.Source,
+		Size:        f.Size,
+		Dest:        f.Dest,
+		Checksum:    checksum,
+		CompletedAt: time.Now(),
+	})
+	if err != nil {
+		e.log.Logf("[ERROR] failed to save file %s to history: %v", f.Name, err)
+	}
+
+	if e.config.RemoveRemote {
+		err = e.downloader.Delete(f)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to delete remote file %s from downloader: %v", f.Name, err)
+		} else {
+			metrics.RemoteDeletions.Inc()
+		}
+	}
+}
+
+// release - возвращает выданный воркеру файл в ожидание
+func (e *Engine) release(f File) {
+	err := e.queue.Release(f.ID)
+	if err != nil {
+		e.log.Logf("[ERROR] failed to release file %s: %v", f.Name, err)
+	}
+}
+
+// keepLease - продлевает аренду файла, пока идет загрузка,
+// при потере аренды вызывает lost с ErrLeaseLost
+func (e *Engine) keepLease(f File, owner string, lost context.CancelCauseFunc) (stop func()) {
+	lease := e.config.LeaseTime()
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := e.queue.Renew(f.ID, owner, lease)
+				if errors.Is(err, ErrLeaseLost) {
+					e.log.Logf("[WARN] lease of file %s lost, stopping download", f.Name)
+					lost(err)
 					return
 				}
 
-				e.log.Logf("[DEBUG] starting download of file %s (size: %d bytes)", f.Name, f.Size)
-
-				err = e.downloader.Download(pc, f)
 				if err != nil {
-					e.log.Logf("[ERROR] failed to download file %s: %v", f.Name, err)
-				} else {
-					e.log.Logf("[INFO] successfully downloaded file %s", f.Name)
-					err = e.queue.Delete(f.ID)
-					if err != nil {
-						e.log.Logf("[ERROR] failed to delete file %s from queue: %v", f.Name, err)
-					}
-
-					if e.config.RemoveRemote {
-						err = e.downloader.Delete(f)
-						if err != nil {
-							e.log.Logf("[ERROR] failed to delete remote file %s from downloader: %v", f.Name, err)
-						}
-					}
+					e.log.Logf("[WARN] failed to renew lease of file %s: %v", f.Name, err)
 				}
-			}(file)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// waitDispatch - ожидает, пока выдача файлов на загрузку разрешена
+// и сервер не ограничивает частоту запросов,
+// возвращает false при завершении контекста
+func (e *Engine) waitDispatch(ctx context.Context) bool {
+	for !e.dispatchAllowed() {
+		select {
 		case <-ctx.Done():
-			return
-		default:
-			time.Sleep(time.Millisecond * 100)
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+
+	if e.config.Breaker != nil && e.config.Breaker.Wait(ctx) != nil {
+		return false
+	}
+
+	return ctx.Err() == nil
+}
+
+// dispatchAllowed - проверяет, что загрузка не приостановлена
+// и текущее время входит в окно загрузки
+func (e *Engine) dispatchAllowed() bool {
+	open := e.config.InWindow(time.Now())
+
+	if e.outside.Swap(!open) == open {
+		if open {
+			e.log.Logf("[INFO] download window opened, dispatching resumed")
+		} else {
+			e.log.Logf("[INFO] outside of download window, dispatching stopped")
 		}
 	}
+
+	return open && !e.paused.Load()
 }
 
 // Данный метод запускает процесс отслеживания прогресса загрузки файлов
 func (e *Engine) progressPrinter(ctx context.Context, items <-chan Progress) {
 	ticker := time.NewTicker(time.Minute * 15)
 
-	speedCounter := NewSpeedData()
-	items = speedCounter.MakeChan(items)
+	items = e.speed.MakeChan(items)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			avgSpeed := speedCounter.AvgSpeed()
+			avgSpeed := e.speed.AvgSpeed()
 
 			stat, err := e.queue.Stat()
 			if err != nil {
@@ -191,44 +385,186 @@ func (e *Engine) progressPrinter(ctx context.Context, items <-chan Progress) {
 			}
 		case progress := <-items:
 			e.log.Logf("[INFO] %s", progress.String())
+			e.update(progress)
 		default:
 			time.Sleep(time.Millisecond * 100)
 		}
 	}
 }
 
-func (e *Engine) acquireFileLock(fileID string) bool {
-	e.lockMutex.Lock()
-	defer e.lockMutex.Unlock()
+// filterTaskFromQueue - завершает задачу без загрузки, если файл
+// уже находится в месте назначения
+//
+// Файл выдан исполнителю owner, поэтому он закрывается через Done
+// с проверкой аренды, а не удаляется из очереди
+func (e *Engine) filterTaskFromQueue(owner string, f File) error {
+	stat, err := e.destStat(f.Dest)
+	if err == nil {
+		if stat.Size() == f.Size {
+			e.log.Logf("[WARN] filter task from queue: %s", f.Name)
+			err = e.queue.Done(f.ID, owner)
+			if err != nil {
+				e.log.Logf("[ERROR] failed to move file %s to the done list: %v", f.Name, err)
+			}
 
-	if _, locked := e.fileLocks[fileID]; locked {
-		return false // File is already being downloaded
+			return errors.New("file is already downloaded")
+		}
 	}
 
-	e.fileLocks[fileID] = true
-	return true
+	return nil
 }
 
-func (e *Engine) releaseFileLock(fileID string) {
-	e.lockMutex.Lock()
-	defer e.lockMutex.Unlock()
+// decide - определяет, что сканирование сделает с найденным файлом
+func (e *Engine) decide(file File, now time.Time) (Action, error) {
+	if e.delivered(file) {
+		return ActionSkip, nil
+	}
+
+	if !e.settle.stable(e.config, file, now) {
+		return ActionWait, nil
+	}
+
+	stat, err := e.destStat(file.Dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if stat != nil && stat.Size() == file.Size {
+		return ActionSkip, nil
+	}
 
-	delete(e.fileLocks, fileID)
+	err = e.queue.Exists(file.ID)
+	switch {
+	case err == nil:
+		return ActionQueued, nil
+	case err != ErrNotFound:
+		return "", err
+	case stat != nil:
+		return ActionOverwrite, nil
+	default:
+		return ActionDownload, nil
+	}
 }
 
-func (e *Engine) filterTaskFromQueue(fileid, filename, filepath string, filesize int64) error {
-	stat, err := os.Stat(filepath)
-	if err == nil {
-		if stat.Size() == filesize {
-			e.log.Logf("[WARN] filter task from queue: %s", filename)
-			err = e.queue.Delete(fileid)
-			if err != nil {
-				e.log.Logf("[ERROR] failed to delete file %s from queue: %v", filename, err)
-			}
+// destStat - возвращает информацию о файле в месте назначения
+func (e *Engine) destStat(path string) (os.FileInfo, error) {
+	return e.target().Stat(path)
+}
 
-			return errors.New("file is already downloaded")
+// target - возвращает место назначения файлов,
+// по умолчанию - локальную файловую систему
+func (e *Engine) target() Target {
+	if e.config.Target != nil {
+		return e.config.Target
+	}
+
+	return localTarget{}
+}
+
+// localTarget - локальная файловая система
+type localTarget struct{}
+
+func (localTarget) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localTarget) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (localTarget) Rename(oldpath, newpath string) error {
+	err := os.MkdirAll(filepath.Dir(newpath), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(oldpath, newpath)
+}
+
+// queuedBySource - возвращает файлы очереди сгруппированные по источнику
+func (e *Engine) queuedBySource() (map[string][]File, error) {
+	files, err := e.queue.List(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]File, len(files))
+	for _, file := range files {
+		result[file.Source] = append(result[file.Source], file)
+	}
+
+	return result, nil
+}
+
+// dropSuperseded - удаляет из очереди устаревшие версии файла
+//
+// Идентификатор файла зависит от размера, поэтому изменившийся
+// в удаленном хранилище файл попадает в очередь как новый
+func (e *Engine) dropSuperseded(file File, queued []File) {
+	for _, old := range queued {
+		if old.ID == file.ID || old.Status.State == StateActive {
+			continue
+		}
+
+		e.log.Logf("[INFO] file %s changed remotely, dropping outdated queue entry %s", file.Name, old.ID)
+		err := e.queue.Delete(old.ID)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to delete outdated queue entry %s: %v", old.ID, err)
+			continue
+		}
+
+		err = os.RemoveAll(old.Temp)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to remove temp directory of %s: %v", old.ID, err)
 		}
 	}
+}
 
-	return nil
+// delivered - проверяет по журналу загрузок, нужно ли пропустить файл
+//
+// В режиме inbox однажды загруженный файл не загружается повторно,
+// в режиме mirror решение принимается по наличию файла в OutputPath
+func (e *Engine) delivered(file File) bool {
+	if e.config.HistoryMode != HistoryModeInbox {
+		return false
+	}
+
+	record, err := e.history.Recall(file.ID)
+	switch err {
+	case nil:
+		e.log.Logf("[DEBUG] file %s was already delivered at %s", file.Name, record.CompletedAt.Format(time.DateTime))
+		return true
+	case ErrNotFound:
+		return false
+	default:
+		e.log.Logf("[ERROR] failed to check file %s in history: %v", file.Name, err)
+		return false
+	}
+}
+
+// failTask - фиксирует неудачный цикл загрузки файла
+//
+// После MaxFailures неудачных циклов файл переносится в список
+// мертвых задач и больше не выдается на загрузку
+func (e *Engine) failTask(f File, reason error) {
+	metrics.FilesFailed.Inc()
+	e.outcomes.record(f, false)
+	attempts := f.Status.Attempts + 1
+
+	if e.config.MaxFailures > 0 && attempts >= e.config.MaxFailures {
+		e.log.Logf("[WARN] file %s failed %d times, moving to dead list", f.Name, attempts)
+		err := e.queue.Bury(f.ID, reason)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to move file %s to dead list: %v", f.Name, err)
+		}
+
+		return
+	}
+
+	next := time.Now().Add(e.config.RetryDelay(attempts))
+	e.log.Logf("[DEBUG] file %s failed %d times, next attempt at %s", f.Name, attempts, next.Format(time.DateTime))
+	err := e.queue.Fail(f.ID, reason, next)
+	if err != nil {
+		e.log.Logf("[ERROR] failed to mark file %s as failed: %v", f.Name, err)
+	}
 }
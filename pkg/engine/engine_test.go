@@ -0,0 +1,747 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/davtest"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/go-pkgz/lgr"
+)
+
+func TestEngine(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	remote := map[string][]byte{
+		"/input/small.txt":     []byte("hello"),
+		"/input/dir/large.bin": bytes.Repeat([]byte("0123456789"), 300_000),
+	}
+
+	for name, data := range remote {
+		if err := srv.WriteFile(name, data); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:        "/input",
+		OutputPath:       dir + "/output",
+		TempPath:         dir + "/temp",
+		Concurrency:      2,
+		PartitionThreads: 2,
+		PartitionSize:    1 << 20,
+		ScanEvery:        time.Millisecond * 100,
+		RemoveRemote:     true,
+		MaxFailures:      3,
+		HistoryMode:      engine.HistoryModeMirror,
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	f := files.New(srv.Client())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e := engine.New(lgr.New(), conf, f, f, q, q)
+	e.Start(ctx)
+
+	deadline := time.Now().Add(time.Second * 30)
+	for {
+		done := true
+		for name := range remote {
+			if srv.Exists(name) {
+				done = false
+			}
+		}
+
+		if done {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("remote files were not downloaded in time")
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	for name, data := range remote {
+		dest := conf.OutputPath + name[len(conf.InputPath):]
+
+		got, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", dest, err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("downloaded data mismatch for %s", name)
+		}
+
+		record, err := q.Recall(engine.NewFile(conf, name, int64(len(data))).ID)
+		if err != nil {
+			t.Errorf("Recall(%s) error = %v", name, err)
+		} else if record.Dest != dest || record.Checksum == "" {
+			t.Errorf("unexpected history record %+v", record)
+		}
+	}
+
+	l, err := q.Len()
+	if err != nil || l != 0 {
+		t.Errorf("Len() = %d, %v, want empty queue", l, err)
+	}
+}
+
+func TestSortFiles(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []engine.File{
+		{ID: "a", Source: "/in/b.bin", Size: 30, ModTime: base.Add(time.Hour)},
+		{ID: "b", Source: "/in/c.bin", Size: 10, ModTime: base.Add(2 * time.Hour)},
+		{ID: "c", Source: "/in/a.bin", Size: 20, ModTime: base},
+		{ID: "d", Source: "/in/urgent/d.bin", Size: 40, ModTime: base.Add(3 * time.Hour), Priority: 1},
+	}
+
+	tests := []struct {
+		order engine.Order
+		want  string
+	}{
+		{order: engine.OrderNone, want: "dabc"},
+		{order: engine.OrderSmallest, want: "dbca"},
+		{order: engine.OrderLargest, want: "dacb"},
+		{order: engine.OrderOldest, want: "dcab"},
+		{order: engine.OrderPath, want: "dcab"},
+	}
+
+	for _, tt := range tests {
+		sorted := slices.Clone(files)
+		engine.SortFiles(sorted, tt.order)
+
+		got := ""
+		for _, file := range sorted {
+			got += file.ID
+		}
+
+		if got != tt.want {
+			t.Errorf("SortFiles(%q) = %s, want %s", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	rules, err := engine.ParsePriorityRules([]string{"/urgent=10", "docs/=5"})
+	if err != nil {
+		t.Fatalf("ParsePriorityRules() error = %v", err)
+	}
+
+	conf := engine.Config{InputPath: "/in", Priorities: rules}
+
+	tests := map[string]int{
+		"/in/urgent/file.bin":   10,
+		"/in/docs/a/file.bin":   5,
+		"/in/urgently/file.bin": 0,
+		"/in/other/file.bin":    0,
+	}
+
+	for source, want := range tests {
+		if got := conf.PriorityOf(engine.File{Source: source}); got != want {
+			t.Errorf("PriorityOf(%s) = %d, want %d", source, got, want)
+		}
+	}
+
+	if _, err := engine.ParsePriorityRules([]string{"/urgent"}); err == nil {
+		t.Error("ParsePriorityRules() should fail without priority")
+	}
+}
+
+func TestOnce(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	for name, data := range map[string][]byte{
+		"/input/good.txt":   []byte("hello"),
+		"/input/broken.txt": []byte("world!"),
+	} {
+		if err := srv.WriteFile(name, data); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:        "/input",
+		OutputPath:       dir + "/output",
+		TempPath:         dir + "/temp",
+		Concurrency:      2,
+		PartitionThreads: 1,
+		ScanEvery:        time.Hour,
+		MaxFailures:      1,
+		HistoryMode:      engine.HistoryModeMirror,
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	f := files.New(srv.Client())
+	srv.Inject(davtest.Fault{Kind: davtest.FaultStatus, Method: "GET", Path: "/input/broken.txt", Status: 500, Times: 100})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	summary, err := engine.New(lgr.New(), conf, f, f, q, q).Once(ctx)
+	if err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if summary.Files != 1 || summary.Bytes != 5 || summary.Failed != 1 {
+		t.Errorf("Once() summary = %s", summary)
+	}
+
+	if _, err := os.Stat(dir + "/output/good.txt"); err != nil {
+		t.Errorf("good.txt should be downloaded: %v", err)
+	}
+}
+
+// closedWindow - окно загрузки, которое никогда не открывается
+type closedWindow struct{}
+
+func (closedWindow) Active(time.Time) bool { return false }
+
+func TestOnceOutsideWindow(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.txt", []byte("hello")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:        "/input",
+		OutputPath:       dir + "/output",
+		TempPath:         dir + "/temp",
+		Concurrency:      1,
+		PartitionThreads: 1,
+		ScanEvery:        time.Hour,
+		HistoryMode:      engine.HistoryModeMirror,
+		Window:           closedWindow{},
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+	defer q.Close()
+
+	f := files.New(srv.Client())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// Запуск не ожидает открытия окна
+	summary, err := engine.New(lgr.New(), conf, f, f, q, q).Once(ctx)
+	if err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if summary.Files != 0 || summary.Pending != 1 {
+		t.Errorf("Once() summary = %s, want 1 pending file", summary)
+	}
+
+	if _, err := os.Stat(dir + "/output/file.txt"); !os.IsNotExist(err) {
+		t.Errorf("file.txt should not be downloaded outside of window")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	for name, data := range map[string][]byte{
+		"/input/new.txt":     []byte("new"),
+		"/input/present.txt": []byte("present"),
+		"/input/changed.txt": []byte("changed"),
+	} {
+		if err := srv.WriteFile(name, data); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:    "/input",
+		OutputPath:   dir + "/output",
+		TempPath:     dir + "/temp",
+		HistoryMode:  engine.HistoryModeMirror,
+		RemoveRemote: true,
+
+		// Первое сканирование поставило бы файлы в очередь
+		SettleScans: 1,
+	}
+
+	if err := os.MkdirAll(conf.OutputPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"present.txt": "present", "changed.txt": "old"} {
+		if err := os.WriteFile(conf.OutputPath+"/"+name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	f := files.New(srv.Client())
+
+	plan, err := engine.New(lgr.New(), conf, f, f, q, q).Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := map[engine.Action]engine.Total{
+		engine.ActionDownload:  {Files: 1, Bytes: 3},
+		engine.ActionOverwrite: {Files: 1, Bytes: 7},
+		engine.ActionSkip:      {Files: 1, Bytes: 7},
+	}
+
+	for action, total := range want {
+		if got := plan.Totals[action]; got != total {
+			t.Errorf("Totals[%s] = %+v, want %+v", action, got, total)
+		}
+	}
+
+	// Уже загруженный файл движок из удаленного хранилища не удаляет
+	if plan.RemoteDelete != (engine.Total{Files: 2, Bytes: 10}) {
+		t.Errorf("RemoteDelete = %+v", plan.RemoteDelete)
+	}
+
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Plan() should not change queue, got %d files", n)
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteTable(&buf); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("/input/new.txt")) {
+		t.Errorf("WriteTable() output misses files:\n%s", buf.String())
+	}
+}
+
+func TestMirrorDeletions(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/kept.txt", []byte("kept")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:     "/input",
+		OutputPath:    dir + "/output",
+		TempPath:      dir + "/temp",
+		Concurrency:   1,
+		HistoryMode:   engine.HistoryModeMirror,
+		MirrorDeletes: engine.DeleteModeTrash,
+		TrashPath:     dir + "/trash",
+		MaxDeletes:    1,
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	// Локальные копии ранее загруженных файлов
+	for _, name := range []string{"kept.txt", "gone.txt", "sub/gone.txt"} {
+		dest := conf.OutputPath + "/" + name
+		if err := os.MkdirAll(dest[:strings.LastIndex(dest, "/")], 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dest, []byte("kept"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		file := engine.NewFile(conf, "/input/"+name, 4)
+		if err := q.Remember(engine.Record{ID: file.ID, Source: file.Source, Size: 4, Dest: file.Dest}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := files.New(srv.Client())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Два удаления превышают лимит, ничего не удаляется
+	if _, err := engine.New(lgr.New(), conf, f, f, q, q).Once(ctx); err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if _, err := os.Stat(conf.OutputPath + "/gone.txt"); err != nil {
+		t.Errorf("gone.txt should be kept when limit is exceeded: %v", err)
+	}
+
+	conf.MaxDeletes = 0
+
+	// Частичное сканирование не удаляет локальные копии
+	summary, err := engine.New(lgr.New(), conf, partialScanner{f}, f, q, q).Once(ctx)
+	if err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if summary.ScanErrors != 1 {
+		t.Errorf("Once() summary = %s, want 1 unreadable directory", summary)
+	}
+
+	if _, err := os.Stat(conf.OutputPath + "/gone.txt"); err != nil {
+		t.Errorf("gone.txt should be kept after partial scan: %v", err)
+	}
+
+	// Копии переносятся через место назначения движка
+	target := &renameTarget{}
+	conf.Target = target
+
+	if _, err := engine.New(lgr.New(), conf, f, f, q, q).Once(ctx); err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if target.renamed != 2 {
+		t.Errorf("Target.Rename() called %d times, want 2", target.renamed)
+	}
+
+	for _, name := range []string{"gone.txt", "sub/gone.txt"} {
+		if _, err := os.Stat(conf.OutputPath + "/" + name); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed from output", name)
+		}
+		if _, err := os.Stat(conf.TrashPath + "/" + name); err != nil {
+			t.Errorf("%s should be moved to trash: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(conf.OutputPath + "/kept.txt"); err != nil {
+		t.Errorf("kept.txt should stay: %v", err)
+	}
+
+	records, err := q.Records()
+	if err != nil || len(records) != 1 {
+		t.Errorf("Records() = %+v, %v; want only kept.txt", records, err)
+	}
+}
+
+// partialScanner - сканер, сообщающий о недоступной директории
+type partialScanner struct {
+	engine.Scanner
+}
+
+func (p partialScanner) Scan(ctx context.Context, conf engine.Config, dir string) ([]engine.File, error) {
+	files, err := p.Scanner.Scan(ctx, conf, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, &engine.ScanError{Dirs: map[string]error{"/input/sub": errors.New("403 Forbidden")}}
+}
+
+// listScanner - сканер, возвращающий заданный список файлов
+type listScanner struct {
+	files []engine.File
+}
+
+func (l *listScanner) Scan(ctx context.Context, conf engine.Config, dir string) ([]engine.File, error) {
+	return l.files, nil
+}
+
+func TestSettle(t *testing.T) {
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:   "/input",
+		OutputPath:  dir + "/output",
+		TempPath:    dir + "/temp",
+		SettleScans: 2,
+		SettleAge:   time.Hour,
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+	defer q.Close()
+
+	now := time.Now()
+	file := func(name string, size int64, modTime time.Time) engine.File {
+		f := engine.NewFile(conf, "/input/"+name, size)
+		f.ModTime = modTime
+		return f
+	}
+
+	fresh, touched, old := now.Add(-time.Minute), now.Add(-time.Second), now.Add(-time.Hour*2)
+
+	scanner := &listScanner{}
+	e := engine.New(lgr.New(), conf, scanner, nil, q, q)
+
+	steps := []struct {
+		name  string
+		files []engine.File
+		want  map[string]engine.Action
+	}{
+		{
+			name:  "first scan waits, old file is settled by age",
+			files: []engine.File{file("a.bin", 1, fresh), file("old.bin", 1, old)},
+			want:  map[string]engine.Action{"/input/a.bin": engine.ActionWait, "/input/old.bin": engine.ActionDownload},
+		},
+		{
+			name:  "unchanged for two scans",
+			files: []engine.File{file("a.bin", 1, fresh)},
+			want:  map[string]engine.Action{"/input/a.bin": engine.ActionDownload},
+		},
+		{
+			name:  "size change resets scans",
+			files: []engine.File{file("a.bin", 2, fresh)},
+			want:  map[string]engine.Action{"/input/a.bin": engine.ActionWait},
+		},
+		{
+			name:  "mtime change resets scans",
+			files: []engine.File{file("a.bin", 2, touched)},
+			want:  map[string]engine.Action{"/input/a.bin": engine.ActionWait},
+		},
+		{
+			name:  "unchanged again",
+			files: []engine.File{file("a.bin", 2, touched)},
+			want:  map[string]engine.Action{"/input/a.bin": engine.ActionDownload},
+		},
+		{
+			name: "vanished file is forgotten",
+		},
+		{
+			name:  "reappeared file waits again",
+			files: []engine.File{file("a.bin", 2, touched)},
+			want:  map[string]engine.Action{"/input/a.bin": engine.ActionWait},
+		},
+	}
+
+	for _, step := range steps {
+		scanner.files = step.files
+
+		plan, err := e.Plan(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Plan() error = %v", step.name, err)
+		}
+
+		got := make(map[string]engine.Action, len(plan.Items))
+		for _, item := range plan.Items {
+			got[item.Source] = item.Action
+
+			if item.Action == engine.ActionWait && item.Settle != "1/2" {
+				t.Errorf("%s: %s settle = %q, want 1/2", step.name, item.Source, item.Settle)
+			}
+		}
+
+		for source, action := range step.want {
+			if got[source] != action {
+				t.Errorf("%s: %s action = %q, want %q", step.name, source, got[source], action)
+			}
+		}
+	}
+}
+
+// renameTarget - локальное место назначения, считающее переносы файлов
+type renameTarget struct {
+	renamed int
+}
+
+func (t *renameTarget) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (t *renameTarget) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (t *renameTarget) Rename(oldpath, newpath string) error {
+	t.renamed++
+
+	if err := os.MkdirAll(filepath.Dir(newpath), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(oldpath, newpath)
+}
+
+// lostQueue - очередь, в которой аренда файла теряется при первом продлении
+type lostQueue struct {
+	*queue.Queue
+}
+
+func (q lostQueue) Renew(id string, owner string, lease time.Duration) error {
+	return engine.ErrLeaseLost
+}
+
+// blockingDownloader - загрузчик, ожидающий отмены загрузки
+type blockingDownloader struct {
+	canceled chan error
+	deleted  atomic.Bool
+}
+
+func (d *blockingDownloader) Download(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File) (string, error) {
+	<-ctx.Done()
+	d.canceled <- context.Cause(ctx)
+	return "", ctx.Err()
+}
+
+func (d *blockingDownloader) Delete(file engine.File) error {
+	d.deleted.Store(true)
+	return nil
+}
+
+func TestLeaseLost(t *testing.T) {
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:    "/input",
+		OutputPath:   dir + "/output",
+		TempPath:     dir + "/temp",
+		Concurrency:  1,
+		ScanEvery:    time.Hour,
+		Lease:        time.Millisecond * 300,
+		RemoveRemote: true,
+		HistoryMode:  engine.HistoryModeMirror,
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+	defer q.Close()
+
+	file := engine.NewFile(conf, "/input/file.bin", 10)
+	scanner := &listScanner{files: []engine.File{file}}
+	downloader := &blockingDownloader{canceled: make(chan error, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine.New(lgr.New(), conf, scanner, downloader, lostQueue{q}, q).Start(ctx)
+
+	select {
+	case cause := <-downloader.canceled:
+		if !errors.Is(cause, engine.ErrLeaseLost) {
+			t.Errorf("download canceled with %v, want lease lost", cause)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("download was not canceled after lease loss")
+	}
+
+	// Результат загрузки не записывается
+	time.Sleep(time.Millisecond * 200)
+
+	list, err := q.List(nil)
+	if err != nil || len(list) != 1 || list[0].Status.Attempts != 0 {
+		t.Errorf("queue = %+v, %v; want untouched file", list, err)
+	}
+
+	if _, err := q.Recall(file.ID); err != engine.ErrNotFound {
+		t.Errorf("Recall() error = %v, want not found", err)
+	}
+
+	if downloader.deleted.Load() {
+		t.Errorf("remote file should not be deleted")
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := srv.WriteFile("/input/file.bin", data); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	conf := engine.Config{
+		InputPath:        "/input",
+		OutputPath:       dir + "/output",
+		TempPath:         dir + "/temp",
+		Concurrency:      1,
+		PartitionThreads: 2,
+		PartitionSize:    4096,
+		ScanEvery:        time.Hour,
+		RemoveRemote:     true,
+		MaxFailures:      1,
+		Retry:            engine.RetryPolicy{MaxAttempts: 1},
+		VerifyChecksum:   true,
+		HistoryMode:      engine.HistoryModeMirror,
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+	defer q.Close()
+
+	// Сервер сообщает контрольную сумму другого содержимого
+	file := engine.NewFile(conf, "/input/file.bin", int64(len(data)))
+	file.Checksum = "sha256:" + strings.Repeat("0", 64)
+
+	f := files.New(srv.Client())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine.New(lgr.New(), conf, &listScanner{files: []engine.File{file}}, f, q, q).Start(ctx)
+
+	var dead []engine.File
+
+	deadline := time.Now().Add(time.Second * 10)
+	for len(dead) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("file with mismatched checksum was not buried in time")
+		}
+
+		time.Sleep(time.Millisecond * 50)
+
+		dead, err = q.Dead()
+		if err != nil {
+			t.Fatalf("Dead() error = %v", err)
+		}
+	}
+
+	if !strings.Contains(dead[0].Status.LastError, engine.ErrChecksumMismatch.Error()) {
+		t.Errorf("LastError = %q, want checksum mismatch", dead[0].Status.LastError)
+	}
+
+	if _, err := os.Stat(file.Dest); !os.IsNotExist(err) {
+		t.Errorf("Stat(Dest) error = %v, want not exist", err)
+	}
+
+	if _, err := os.Stat(file.Temp); !os.IsNotExist(err) {
+		t.Errorf("Stat(Temp) error = %v, want removed partitions", err)
+	}
+
+	if !srv.Exists("/input/file.bin") {
+		t.Errorf("remote file should not be deleted")
+	}
+
+	if _, err := q.Recall(file.ID); err != engine.ErrNotFound {
+		t.Errorf("Recall() error = %v, want not found", err)
+	}
+}
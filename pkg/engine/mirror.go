@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+)
+
+// deletions - возвращает записи журнала о файлах, удаленных
+// из удаленного хранилища после загрузки
+//
+// Возвращает ошибку, если удаление выглядит небезопасным: сканирование
+// завершилось с ошибкой scanErr, удаленное хранилище вернуло пустой
+// список или удалений больше MaxDeletes
+func (e *Engine) deletions(files []File, scanErr error) ([]Record, error) {
+	if scanErr != nil {
+		return nil, fmt.Errorf("scan is incomplete: %w", scanErr)
+	}
+
+	records, err := e.history.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file.Source] = true
+	}
+
+	prefix := strings.TrimSuffix(e.config.InputPath, "/") + "/"
+
+	var result []Record
+	for _, record := range records {
+		if present[record.Source] || !strings.HasPrefix(record.Source, prefix) {
+			continue
+		}
+
+		// Файл мог пропасть из списка из-за изменения фильтра
+		// или ограничения глубины сканирования
+		if e.filtered(record) || e.tooDeep(record) {
+			continue
+		}
+
+		result = append(result, record)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("remote listing is empty, refusing to delete %d local files", len(result))
+	}
+
+	if e.config.MaxDeletes > 0 && len(result) > e.config.MaxDeletes {
+		return nil, fmt.Errorf("%d local files to delete exceed limit of %d", len(result), e.config.MaxDeletes)
+	}
+
+	return result, nil
+}
+
+// filtered - проверяет, исключен ли файл из записи журнала фильтром
+func (e *Engine) filtered(record Record) bool {
+	if e.config.Filter == nil {
+		return false
+	}
+
+	relative := strings.TrimPrefix(record.Source, e.config.InputPath)
+	for dir := path.Dir(relative); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if e.config.Filter.SkipDir(dir) {
+			return true
+		}
+	}
+
+	return e.config.Filter.Skip(relative, record.Size)
+}
+
+// tooDeep - проверяет, находится ли файл из записи журнала
+// глубже ограничения сканирования MaxDepth
+func (e *Engine) tooDeep(record Record) bool {
+	if e.config.MaxDepth <= 0 {
+		return false
+	}
+
+	dir := strings.Trim(path.Dir(strings.TrimPrefix(record.Source, e.config.InputPath)), "/")
+	return dir != "" && dir != "." && strings.Count(dir, "/")+1 > e.config.MaxDepth
+}
+
+// mirrorDeletions - удаляет или переносит в корзину локальные копии
+// файлов, удаленных из удаленного хранилища
+func (e *Engine) mirrorDeletions(files []File, scanErr error) {
+	if e.config.MirrorDeletes != DeleteModeRemove && e.config.MirrorDeletes != DeleteModeTrash {
+		return
+	}
+
+	records, err := e.deletions(files, scanErr)
+	if err != nil {
+		e.log.Logf("[WARN] mirror deletions skipped: %v", err)
+		return
+	}
+
+	for _, record := range records {
+		err = e.deleteLocal(record)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to delete local copy of %s: %v", record.Source, err)
+			continue
+		}
+
+		err = e.history.Forget(record.ID)
+		if err != nil {
+			e.log.Logf("[ERROR] failed to remove %s from history: %v", record.Source, err)
+		}
+	}
+}
+
+// deleteLocal - удаляет или переносит в корзину копию файла
+// в месте назначения
+//
+// Отсутствующие и измененные локально файлы не затрагиваются
+func (e *Engine) deleteLocal(record Record) error {
+	stat, err := e.destStat(record.Dest)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() != record.Size {
+		e.log.Logf("[WARN] local copy %s was modified, keeping it", record.Dest)
+		return nil
+	}
+
+	if e.config.MirrorDeletes == DeleteModeTrash {
+		trash := e.trashPath(record.Dest)
+
+		e.log.Logf("[INFO] file %s was deleted remotely, moving local copy to %s", record.Source, trash)
+		err = e.target().Rename(record.Dest, trash)
+	} else {
+		e.log.Logf("[INFO] file %s was deleted remotely, deleting local copy", record.Source)
+		err = e.target().Remove(record.Dest)
+	}
+
+	if err == nil {
+		metrics.LocalDeletions.Inc()
+	}
+
+	return err
+}
+
+// trashPath - возвращает путь в корзине для локального файла,
+// существующие в корзине файлы не перезаписываются
+func (e *Engine) trashPath(dest string) string {
+	relative := strings.TrimPrefix(dest, e.config.OutputPath)
+	trash := filepath.Join(e.config.TrashPath, relative)
+
+	if _, err := e.destStat(trash); err == nil {
+		trash += "." + time.Now().Format("20060102-150405")
+	}
+
+	return trash
+}
@@ -5,15 +5,50 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+)
+
+var (
+	ErrNotFound = errors.New("file not found")
+	ErrActive   = errors.New("file is being downloaded")
+
+	// Аренда файла истекла и он был выдан другому исполнителю
+	ErrLeaseLost = errors.New("file lease lost")
 
-	"github.com/go-pkgz/lgr"
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// Загрузка остановлена на границе части, так как закончилось
+	// окно загрузки; не считается неудачей
+	ErrPaused = errors.New("download paused outside of window")
 )
 
-var ErrNotFound = errors.New("file not found")
+// ScanError - сканирование завершено, но часть директорий прочитать не удалось
+//
+// Найденные файлы обрабатываются, а удаление локальных копий
+// пропускается, так как список файлов неполный
+type ScanError struct {
+	Dirs map[string]error
+}
+
+func (e *ScanError) Error() string {
+	dirs := make([]string, 0, len(e.Dirs))
+	for dir := range e.Dirs {
+		dirs = append(dirs, dir)
+	}
+
+	slices.Sort(dirs)
+	return fmt.Sprintf("failed to read %d directories, %s: %v", len(dirs), dirs[0], e.Dirs[dirs[0]])
+}
+
+// Размер части файла по умолчанию
+const DefaultPartitionSize int64 = 64 << 20 // 64 MB
 
 type Config struct {
 	// Путь к директории из которой будут скачиваться файлы
@@ -28,22 +63,343 @@ type Config struct {
 	// Количество потоков для скачивания файлов
 	Concurrency int
 
+	// Количество потоков для скачивания частей одного файла
+	PartitionThreads int
+
+	// Размер части файла в байтах
+	//
+	// Значение сохраняется в каждом файле очереди, поэтому его
+	// изменение не затрагивает уже начатые загрузки
+	PartitionSize int64
+
 	// Интервал сканирования файлов
 	ScanEvery time.Duration
 
+	// Количество директорий, читаемых одновременно при сканировании
+	// (0 или 1 - последовательно)
+	ScanThreads int
+
+	// Максимальная глубина вложенности сканируемых директорий
+	// относительно InputPath (0 - без ограничения)
+	//
+	// Файлы глубже ограничения не считаются удаленными
+	MaxDepth int
+
 	// Флаг удаления удаленных файлов
 	//
 	// Полезен в случае, если удаленный Storage следует чистить
 	// в автоматическом режиме
 	RemoveRemote bool
+
+	// Количество неудачных циклов загрузки, после которого
+	// файл переносится в список мертвых задач
+	MaxFailures int
+
+	// Задержка перед повторным циклом загрузки после неудачи
+	//
+	// Каждая следующая неудача удваивает задержку
+	FailureDelay time.Duration
+
+	// Режим учета ранее загруженных файлов при сканировании
+	HistoryMode HistoryMode
+
+	// Фильтр файлов при сканировании (nil - загружать все)
+	Filter Filter
+
+	// Проверять контрольную сумму загруженного файла,
+	// если удаленное хранилище ее предоставляет
+	VerifyChecksum bool
+
+	// Количество последовательных сканирований, в которых файл должен
+	// иметь неизменные размер и время изменения, прежде чем попасть в очередь
+	//
+	// 0 - проверка отключена
+	SettleScans int
+
+	// Возраст файла, после которого он считается загруженным в удаленное
+	// хранилище полностью, независимо от количества сканирований
+	//
+	// 0 - проверка отключена
+	SettleAge time.Duration
+
+	// Ограничение скорости загрузки (nil - без ограничения)
+	Throttle Throttle
+
+	// Окна, в которые разрешена загрузка (nil - в любое время)
+	//
+	// Вне окна новые файлы не выдаются на загрузку,
+	// сканирование при этом продолжается
+	Window Window
+
+	// Останавливать начатые загрузки на границе части
+	// при выходе из окна загрузки
+	PauseInFlight bool
+
+	// Порядок выдачи файлов из очереди
+	Order Order
+
+	// Приоритеты файлов, назначаемые при добавлении в очередь
+	Priorities []PriorityRule
+
+	// Время аренды файла исполнителем, аренда продлевается
+	// во время загрузки, по истечении файл выдается повторно
+	Lease time.Duration
+
+	// Действие с локальными копиями файлов, удаленных
+	// из удаленного хранилища
+	//
+	// Учитываются только файлы из журнала загрузок
+	MirrorDeletes DeleteMode
+
+	// Директория для локальных копий в режиме DeleteModeTrash
+	TrashPath string
+
+	// Максимальное количество удалений за одно сканирование,
+	// при превышении удаление не выполняется (0 - без ограничения)
+	MaxDeletes int
+
+	// Место назначения файлов, в котором сканирование ищет
+	// уже перенесенные файлы (nil - локальная файловая система)
+	Target Target
+
+	// Общий для нескольких движков лимит одновременных
+	// загрузок (nil - ограничено только Concurrency)
+	Slots Slots
+
+	// Состояние директорий для инкрементального сканирования
+	// (nil - каждое сканирование читает все директории)
+	//
+	// Директория с прежней версией не читается повторно, поэтому источник
+	// должен менять версию директории при изменении любого вложенного
+	// файла, как это делают ctag и etag Nextcloud и ownCloud
+	ScanCache ScanCache
+
+	// Каждое N-е сканирование читает все директории
+	// независимо от их версий (0 - никогда)
+	FullScanEvery int
+
+	// Повторы запросов к удаленному хранилищу внутри одного цикла загрузки
+	Retry RetryPolicy
+
+	// Общая для всех воркеров пауза при ограничении частоты
+	// запросов сервером (nil - пауза только у получившего отказ)
+	Breaker Breaker
+}
+
+// LeaseTime - возвращает время аренды файла исполнителем
+func (c Config) LeaseTime() time.Duration {
+	if c.Lease <= 0 {
+		return time.Minute * 10
+	}
+
+	return c.Lease
+}
+
+// Window - расписание, в которое разрешена загрузка
+type Window interface {
+	Active(t time.Time) bool
+}
+
+// InWindow - проверяет, разрешена ли загрузка в указанный момент
+func (c Config) InWindow(t time.Time) bool {
+	return c.Window == nil || c.Window.Active(t)
+}
+
+// Interrupted - проверяет, нужно ли остановить начатую загрузку
+func (c Config) Interrupted(t time.Time) bool {
+	return c.PauseInFlight && !c.InWindow(t)
+}
+
+// Throttle - ограничение скорости загрузки
+type Throttle interface {
+	// Limit - возвращает обертку для потоков одного файла,
+	// завершение ctx прерывает ожидание в потоках
+	Limit(ctx context.Context) func(io.Reader) io.Reader
+}
+
+// Slots - лимит одновременных загрузок, разделяемый движками
+type Slots interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+// Breaker - пауза всех запросов к удаленному хранилищу
+type Breaker interface {
+	// Trip - приостанавливает запросы на время d,
+	// более ранняя уже назначенная пауза продлевается
+	Trip(d time.Duration)
+
+	// Wait - ожидает окончания паузы или завершения контекста
+	Wait(ctx context.Context) error
+}
+
+// Target - место назначения файлов
+//
+// Для отсутствующего файла Stat возвращает ошибку, соответствующую os.ErrNotExist
+type Target interface {
+	Stat(path string) (os.FileInfo, error)
+
+	// Remove - удаляет файл
+	Remove(path string) error
+
+	// Rename - переносит файл, создавая недостающие директории нового пути
+	Rename(oldpath, newpath string) error
+}
+
+// ScanCache - состояние директорий источника между сканированиями
+//
+// Пути директорий передаются в виде path.Clean
+type ScanCache interface {
+	// ScannedDir - возвращает состояние директории, nil если его нет
+	ScannedDir(path string) (*DirState, error)
+
+	// SaveDirs - сохраняет состояния директорий, nil удаляет
+	// состояние директории и всех вложенных директорий
+	SaveDirs(states map[string]*DirState) error
+}
+
+// DirState - содержимое директории на момент сканирования
+type DirState struct {
+	// Версия директории: ctag, etag или время изменения
+	Version string
+	Entries []DirEntry
+}
+
+// DirEntry - элемент директории
+type DirEntry struct {
+	Name     string
+	Dir      bool
+	Version  string // Версия вложенной директории
+	Size     int64
+	ModTime  time.Time
+	Checksum string
+}
+
+// Filter - отбор файлов при сканировании
+//
+// Пути передаются относительно InputPath
+type Filter interface {
+	SkipDir(path string) bool
+	Skip(path string, size int64) bool
+}
+
+// Режим учета ранее загруженных файлов
+type HistoryMode string
+
+const (
+	// Файл загружается повторно, если его нет в OutputPath
+	HistoryModeMirror HistoryMode = "mirror"
+
+	// Однажды загруженный файл больше никогда не загружается,
+	// даже если его переместили или удалили локально
+	HistoryModeInbox HistoryMode = "inbox"
+)
+
+// Действие с локальной копией файла, удаленного из удаленного хранилища
+type DeleteMode string
+
+const (
+	// Локальная копия сохраняется
+	DeleteModeNone DeleteMode = "none"
+
+	// Локальная копия удаляется
+	DeleteModeRemove DeleteMode = "delete"
+
+	// Локальная копия переносится в TrashPath
+	DeleteModeTrash DeleteMode = "trash"
+)
+
+// RetryDelay - возвращает задержку перед следующим циклом загрузки
+// для файла с указанным количеством неудачных попыток
+func (c Config) RetryDelay(attempts int) time.Duration {
+	const maxDelay = time.Hour
+
+	delay := c.FailureDelay
+	if delay <= 0 {
+		delay = time.Minute
+	}
+
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// RetryPolicy - повторы неудачных запросов к удаленному хранилищу
+type RetryPolicy struct {
+	// Количество попыток, включая первую (0 - 3 попытки)
+	MaxAttempts int
+
+	// Задержка перед первым повтором, каждый следующий
+	// повтор удваивает задержку (0 - 2 секунды)
+	Base time.Duration
+
+	// Максимальная задержка между попытками (0 - минута)
+	//
+	// Ограничивает и задержку, запрошенную сервером в Retry-After,
+	// чтобы один ответ не останавливал все воркеры надолго
+	Cap time.Duration
+
+	// Доля задержки, на которую она случайно уменьшается или
+	// увеличивается, чтобы воркеры не повторяли запросы одновременно
+	Jitter float64
+}
+
+// Attempts - возвращает количество попыток
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts <= 0 {
+		return 3
+	}
+
+	return p.MaxAttempts
+}
+
+// MaxDelay - возвращает максимальную задержку между попытками
+func (p RetryPolicy) MaxDelay() time.Duration {
+	if p.Cap <= 0 {
+		return time.Minute
+	}
+
+	return p.Cap
+}
+
+// ServerDelay - возвращает задержку, запрошенную сервером,
+// ограниченную MaxDelay
+func (p RetryPolicy) ServerDelay(after time.Duration) time.Duration {
+	return min(after, p.MaxDelay())
+}
+
+// Delay - возвращает задержку перед повтором после неудачной попытки attempt
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay, limit := p.Base, p.MaxDelay()
+	if delay <= 0 {
+		delay = time.Second * 2
+	}
+
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+
+	delay = min(delay, limit)
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * min(p.Jitter, 1) * float64(delay))
+	}
+
+	return delay
 }
 
 type Scanner interface {
-	Scan(Config, string) ([]File, error)
+	// Scan - возвращает файлы директории, отмена ctx прерывает сканирование
+	Scan(ctx context.Context, conf Config, dir string) ([]File, error)
 }
 
 type Downloader interface {
-	Download(pch chan<- Progress, file File) error
+	// Download - загружает файл и возвращает его контрольную сумму
+	//
+	// Отмена ctx (завершение работы или потеря аренды файла)
+	// прерывает загрузку с ошибкой ctx.Err()
+	Download(ctx context.Context, conf Config, pch chan<- Progress, file File) (string, error)
 	Delete(file File) error
 }
 
@@ -53,13 +409,62 @@ type Queue interface {
 	Len() (int, error)
 	Stat() (*Stat, error)
 	List(filter func(f File) error) ([]File, error)
-	Chan(ctx context.Context, log lgr.L, filter func(f File) error) <-chan File
 	Delete(id string) error
+	SetPriority(id string, priority int) error
+
+	// Claim - ожидает и выдает исполнителю owner следующий файл
+	Claim(ctx context.Context, owner string, order Order, lease time.Duration) (File, error)
+	Renew(id string, owner string, lease time.Duration) error
+	Fail(id string, reason error, next time.Time) error
+	Bury(id string, reason error) error
+	Release(id string) error
+
+	// Done - переносит файл, выданный исполнителю owner,
+	// в список завершенных задач
+	Done(id string, owner string) error
+	Dead() ([]File, error)
+}
+
+type History interface {
+	Remember(record Record) error
+	Recall(id string) (*Record, error)
+	Records() ([]Record, error)
+	Forget(id string) error
+}
+
+// Record - запись журнала загруженных файлов
+type Record struct {
+	// Уникальный идентификатор файла
+	ID string
+
+	// Место из которого файл был загружен
+	Source string
+
+	// Размер файла в байтах
+	Size int64
+
+	// Место в которое файл был загружен
+	Dest string
+
+	// Контрольная сумма загруженного файла (sha256)
+	Checksum string
+
+	// Время завершения загрузки
+	CompletedAt time.Time
 }
 
 type Stat struct {
 	Files    int
 	FullSize int64
+
+	// Количество файлов по состояниям
+	Active int
+	Failed int
+	Dead   int
+	Done   int
+
+	// Количество файлов в журнале загрузок
+	Delivered int
 }
 
 func (s *Stat) AvgTime(speed int64) time.Duration {
@@ -74,13 +479,80 @@ func NewFile(conf Config, source string, size int64) File {
 	hash := md5.Sum([]byte(source + "_" + fmt.Sprint(size)))
 	fileID := fmt.Sprintf("%x", hash)
 
+	partitionSize := conf.PartitionSize
+	if partitionSize <= 0 {
+		partitionSize = DefaultPartitionSize
+	}
+
 	return File{
-		ID:     fileID,
-		Name:   filepath.Base(source),
-		Source: source,
-		Dest:   conf.OutputPath + strings.TrimPrefix(source, conf.InputPath),
-		Temp:   filepath.Join(conf.TempPath, fileID),
-		Size:   size,
+		ID:            fileID,
+		Name:          filepath.Base(source),
+		Source:        source,
+		Dest:          conf.OutputPath + strings.TrimPrefix(source, conf.InputPath),
+		Temp:          filepath.Join(conf.TempPath, fileID),
+		Size:          size,
+		PartitionSize: partitionSize,
+	}
+}
+
+// Состояние файла в очереди
+type State string
+
+const (
+	// Файл ожидает загрузки
+	StatePending State = "pending"
+
+	// Файл загружается в данный момент
+	StateActive State = "active"
+
+	// Последний цикл загрузки завершился ошибкой,
+	// файл будет загружен повторно после NextAt
+	StateFailed State = "failed"
+
+	// Загрузка завершена, файл перенесен в список завершенных задач
+	StateDone State = "done"
+
+	// Файл исчерпал количество попыток и перенесен
+	// в список мертвых задач
+	StateDead State = "dead"
+)
+
+// Status - состояние файла в очереди
+type Status struct {
+	// Текущее состояние
+	State State
+
+	// Количество неудачных циклов загрузки
+	Attempts int
+
+	// Текст последней ошибки
+	LastError string
+
+	// Время после которого файл может быть загружен повторно
+	NextAt time.Time
+
+	// Время последнего изменения состояния
+	UpdatedAt time.Time
+
+	// Исполнитель, которому выдан активный файл
+	Owner string
+
+	// Время истечения аренды активного файла
+	LeaseUntil time.Time
+}
+
+// Ready - проверяет, может ли файл быть выдан на загрузку
+func (s Status) Ready(now time.Time) bool {
+	switch s.State {
+	case "", StatePending:
+		return true
+	case StateFailed:
+		return !s.NextAt.After(now)
+	case StateActive:
+		// Исполнитель не продлил аренду
+		return !s.LeaseUntil.IsZero() && !s.LeaseUntil.After(now)
+	default:
+		return false
 	}
 }
 
@@ -102,6 +574,23 @@ type File struct {
 
 	// Размер файла в байтах
 	Size int64
+
+	// Время последнего изменения файла в удаленном хранилище
+	ModTime time.Time
+
+	// Контрольная сумма файла в удаленном хранилище
+	// в формате "алгоритм:значение" (md5, sha1, sha256)
+	Checksum string
+
+	// Размер части файла в байтах, с которым была начата загрузка
+	PartitionSize int64
+
+	// Приоритет загрузки, файлы с большим приоритетом
+	// выдаются из очереди первыми
+	Priority int
+
+	// Состояние файла в очереди
+	Status Status
 }
 
 type Progress struct {
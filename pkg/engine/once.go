@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Summary - итог однократной синхронизации
+type Summary struct {
+	// Количество загруженных файлов
+	Files int
+
+	// Объем загруженных файлов в байтах
+	Bytes int64
+
+	// Количество файлов, загрузка которых завершилась ошибкой
+	Failed int
+
+	// Количество директорий, которые не удалось прочитать при сканировании
+	ScanErrors int
+
+	// Количество файлов, оставшихся в очереди, так как выдача
+	// остановлена окном загрузки или паузой
+	Pending int
+
+	// Время синхронизации
+	Elapsed time.Duration
+}
+
+func (s Summary) String() string {
+	result := fmt.Sprintf("files: %d, bytes: %d, failed: %d, elapsed: %s",
+		s.Files, s.Bytes, s.Failed, s.Elapsed.Round(time.Millisecond))
+
+	if s.ScanErrors > 0 {
+		result += fmt.Sprintf(", unreadable directories: %d", s.ScanErrors)
+	}
+
+	if s.Pending > 0 {
+		result += fmt.Sprintf(", pending: %d", s.Pending)
+	}
+
+	return result
+}
+
+// Once - выполняет однократную синхронизацию
+//
+// Сканирование запускается сразу, после чего воркеры загружают файлы,
+// пока в очереди остаются ожидающие или загружаемые файлы. Файлы,
+// загрузка которых завершилась ошибкой, не ожидают повторной попытки.
+// Если выдача файлов остановлена окном загрузки или паузой, запуск
+// завершается после начатых загрузок, оставшиеся файлы учитываются в Pending
+func (e *Engine) Once(ctx context.Context) (*Summary, error) {
+	started := time.Now()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressCH := make(chan Progress, e.config.Concurrency)
+	go e.progressPrinter(ctx, progressCH)
+
+	err := e.scan(ctx, e.config.InputPath)
+
+	var partial *ScanError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
+	}
+
+	go e.downloadFiles(ctx, progressCH, e.config.Concurrency)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var pending int
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		// Начатые загрузки завершаются и вне окна загрузки
+		if len(e.Active()) > 0 {
+			continue
+		}
+
+		pending, err = e.pending()
+		if err != nil {
+			return nil, err
+		}
+
+		if pending == 0 || !e.dispatchAllowed() {
+			break
+		}
+	}
+
+	summary := e.outcomes.summary()
+	summary.Elapsed = time.Since(started)
+	summary.Pending = pending
+	if partial != nil {
+		summary.ScanErrors = len(partial.Dirs)
+	}
+	return &summary, nil
+}
+
+// pending - возвращает количество файлов очереди, ожидающих
+// загрузки в текущем запуске или загружаемых в данный момент
+func (e *Engine) pending() (int, error) {
+	files, err := e.queue.List(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		switch file.Status.State {
+		case "", StatePending, StateActive:
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// outcomes - результаты загрузки файлов в текущем запуске,
+// для каждого файла учитывается последний результат
+type outcomes struct {
+	mx    sync.Mutex
+	items map[string]outcome
+}
+
+type outcome struct {
+	size int64
+	ok   bool
+}
+
+func newOutcomes() *outcomes {
+	return &outcomes{items: make(map[string]outcome)}
+}
+
+func (o *outcomes) record(file File, ok bool) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	o.items[file.ID] = outcome{size: file.Size, ok: ok}
+}
+
+func (o *outcomes) summary() Summary {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	var s Summary
+	for _, item := range o.items {
+		if !item.ok {
+			s.Failed++
+			continue
+		}
+
+		s.Files++
+		s.Bytes += item.size
+	}
+
+	return s
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Порядок выдачи файлов из очереди
+type Order string
+
+const (
+	// Порядок хранения в очереди
+	OrderNone Order = "none"
+
+	// Сначала файлы меньшего размера
+	OrderSmallest Order = "smallest"
+
+	// Сначала файлы большего размера
+	OrderLargest Order = "largest"
+
+	// Сначала файлы с более ранним временем изменения
+	// в удаленном хранилище
+	OrderOldest Order = "oldest"
+
+	// В лексикографическом порядке пути
+	OrderPath Order = "path"
+)
+
+// SortFiles - упорядочивает файлы для выдачи на загрузку
+//
+// Файлы с большим приоритетом выдаются первыми независимо
+// от порядка, внутри одного приоритета действует order
+func SortFiles(files []File, order Order) {
+	slices.SortStableFunc(files, func(a, b File) int {
+		return CompareFiles(a, b, order)
+	})
+}
+
+// CompareFiles - сравнивает файлы в порядке выдачи на загрузку,
+// отрицательный результат означает, что a выдается раньше b
+func CompareFiles(a, b File, order Order) int {
+	if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+		return c
+	}
+
+	switch order {
+	case OrderSmallest:
+		return cmp.Compare(a.Size, b.Size)
+	case OrderLargest:
+		return cmp.Compare(b.Size, a.Size)
+	case OrderOldest:
+		return a.ModTime.Compare(b.ModTime)
+	case OrderPath:
+		return strings.Compare(a.Source, b.Source)
+	default:
+		return 0
+	}
+}
+
+// PriorityRule - приоритет файлов, путь которых начинается с Prefix
+//
+// Путь указывается относительно InputPath
+type PriorityRule struct {
+	Prefix   string
+	Priority int
+}
+
+// ParsePriorityRules - разбирает правила приоритета вида "/urgent=10"
+func ParsePriorityRules(items []string) ([]PriorityRule, error) {
+	rules := make([]PriorityRule, 0, len(items))
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		prefix, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("priority rule %q: expected PATH=PRIORITY", item)
+		}
+
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("priority rule %q: invalid priority", item)
+		}
+
+		rules = append(rules, PriorityRule{
+			Prefix:   "/" + strings.Trim(strings.TrimSpace(prefix), "/"),
+			Priority: priority,
+		})
+	}
+
+	return rules, nil
+}
+
+// PriorityOf - возвращает приоритет файла по правилам конфигурации,
+// при совпадении нескольких правил выигрывает первое
+func (c Config) PriorityOf(file File) int {
+	path := "/" + strings.Trim(strings.TrimPrefix(file.Source, c.InputPath), "/")
+
+	for _, rule := range c.Priorities {
+		if path == rule.Prefix || rule.Prefix == "/" || strings.HasPrefix(path, rule.Prefix+"/") {
+			return rule.Priority
+		}
+	}
+
+	return 0
+}
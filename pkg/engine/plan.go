@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Action - действие сканирования с найденным файлом
+type Action string
+
+const (
+	// Файл будет добавлен в очередь загрузки
+	ActionDownload Action = "download"
+
+	// Файл будет загружен повторно поверх локального
+	// файла другого размера
+	ActionOverwrite Action = "overwrite"
+
+	// Файл уже загружен и будет пропущен
+	ActionSkip Action = "skip"
+
+	// Файл уже находится в очереди загрузки
+	ActionQueued Action = "queued"
+
+	// Файл еще изменяется в удаленном хранилище
+	ActionWait Action = "wait"
+
+	// Файл удален из удаленного хранилища, локальная копия
+	// будет удалена или перенесена в корзину
+	ActionDeleteLocal Action = "delete-local"
+)
+
+// Plan - результат пробного сканирования
+type Plan struct {
+	Items []PlanItem
+
+	// Количество и объем файлов по действиям
+	Totals map[Action]Total
+
+	// Количество и объем файлов, которые будут удалены
+	// из удаленного хранилища
+	RemoteDelete Total
+
+	// Причины, по которым часть действий не будет выполнена
+	Warnings []string
+}
+
+// PlanItem - действие с одним файлом
+type PlanItem struct {
+	Action Action
+	Source string
+	Dest   string
+	Size   int64
+
+	// Файл будет удален из удаленного хранилища
+	RemoteDelete bool
+
+	// Для ожидающего файла - количество сканирований без изменений
+	// и необходимое их количество, например "1/3"
+	Settle string
+}
+
+// Total - количество и объем файлов
+type Total struct {
+	Files int
+	Bytes int64
+}
+
+// NewPlan - возвращает пустой план
+func NewPlan() *Plan {
+	return &Plan{Totals: make(map[Action]Total)}
+}
+
+// Plan - выполняет сканирование без изменения очереди и файлов
+// и возвращает действия, которые будут выполнены с файлами
+func (e *Engine) Plan(ctx context.Context) (*Plan, error) {
+	files, scanErr := e.scanner.Scan(ctx, e.config, e.config.InputPath)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var partial *ScanError
+	if scanErr != nil && !errors.As(scanErr, &partial) {
+		return nil, scanErr
+	}
+
+	plan := NewPlan()
+	if partial != nil {
+		plan.WarnScan(partial)
+	}
+
+	// Сканирование учитывается так же, как в работающем движке. Пробный
+	// запуск видит файлы один раз, поэтому при SettleScans >= 2 новые
+	// файлы ожидают и в плане указывается, сколько сканирований осталось
+	e.settle.observe(files)
+	now := time.Now()
+
+	for _, file := range files {
+		action, err := e.decide(file, now)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", file.Source, err)
+		}
+
+		item := PlanItem{
+			Action: action,
+			Source: file.Source,
+			Dest:   file.Dest,
+			Size:   file.Size,
+		}
+
+		switch action {
+		case ActionDownload, ActionOverwrite, ActionQueued:
+			item.RemoteDelete = e.config.RemoveRemote
+		case ActionWait:
+			if e.config.SettleScans > 0 {
+				item.Settle = fmt.Sprintf("%d/%d", e.settle.scans(file), e.config.SettleScans)
+			}
+		}
+
+		plan.Add(item)
+	}
+
+	if e.config.MirrorDeletes == DeleteModeRemove || e.config.MirrorDeletes == DeleteModeTrash {
+		records, err := e.deletions(files, scanErr)
+		if err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("mirror deletions skipped: %v", err))
+		}
+
+		for _, record := range records {
+			plan.Add(PlanItem{
+				Action: ActionDeleteLocal,
+				Source: record.Source,
+				Dest:   record.Dest,
+				Size:   record.Size,
+			})
+		}
+	}
+
+	return plan, nil
+}
+
+// Add - добавляет действие в план и учитывает его в итогах
+func (p *Plan) Add(item PlanItem) {
+	p.Items = append(p.Items, item)
+
+	total := p.Totals[item.Action]
+	total.Files++
+	total.Bytes += item.Size
+	p.Totals[item.Action] = total
+
+	if item.RemoteDelete {
+		p.RemoteDelete.Files++
+		p.RemoteDelete.Bytes += item.Size
+	}
+}
+
+// WarnScan - добавляет в план предупреждения о непрочитанных директориях
+func (p *Plan) WarnScan(partial *ScanError) {
+	for dir, err := range partial.Dirs {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("failed to read directory %s: %v", dir, err))
+	}
+
+	slices.Sort(p.Warnings)
+}
+
+// WriteTable - выводит план в виде таблицы
+func (p *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ACTION\tSIZE\tREMOTE\tSOURCE\tDEST")
+	for _, item := range p.Items {
+		remote := ""
+		if item.RemoteDelete {
+			remote = "delete"
+		}
+
+		action := string(item.Action)
+		if item.Settle != "" {
+			action += " (pending settle " + item.Settle + ")"
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", action, item.Size, remote, item.Source, item.Dest)
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ACTION\tFILES\tBYTES")
+	for _, action := range []Action{ActionDownload, ActionOverwrite, ActionQueued, ActionWait, ActionSkip, ActionDeleteLocal} {
+		total := p.Totals[action]
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", action, total.Files, total.Bytes)
+	}
+
+	fmt.Fprintf(tw, "remote-delete\t%d\t%d\n", p.RemoteDelete.Files, p.RemoteDelete.Bytes)
+
+	for _, warning := range p.Warnings {
+		fmt.Fprintf(tw, "\nWARNING: %s\n", warning)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+func newSettler() *settler {
+	return &settler{
+		seen: make(map[string]observation),
+	}
+}
+
+// settler - отслеживает файлы, которые еще могут загружаться
+// в удаленное хранилище другим клиентом
+type settler struct {
+	mx   sync.Mutex
+	seen map[string]observation
+}
+
+type observation struct {
+	size    int64
+	modTime time.Time
+	scans   int
+}
+
+// observe - учитывает результаты очередного сканирования
+//
+// Файлы, отсутствующие в результатах, забываются
+func (s *settler) observe(files []File) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	seen := make(map[string]observation, len(files))
+
+	for _, file := range files {
+		item, ok := s.seen[file.Source]
+		if ok && item.size == file.Size && item.modTime.Equal(file.ModTime) {
+			item.scans++
+		} else {
+			item = observation{
+				size:    file.Size,
+				modTime: file.ModTime,
+				scans:   1,
+			}
+		}
+
+		seen[file.Source] = item
+	}
+
+	s.seen = seen
+}
+
+// scans - возвращает количество сканирований, в которых файл не изменялся
+func (s *settler) scans(file File) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.seen[file.Source].scans
+}
+
+// stable - проверяет, что файл не изменяется и может быть загружен
+func (s *settler) stable(conf Config, file File, now time.Time) bool {
+	if conf.SettleScans <= 0 && conf.SettleAge <= 0 {
+		return true
+	}
+
+	if conf.SettleAge > 0 && !file.ModTime.IsZero() && now.Sub(file.ModTime) >= conf.SettleAge {
+		return true
+	}
+
+	if conf.SettleScans <= 0 {
+		return false
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.seen[file.Source].scans >= conf.SettleScans
+}
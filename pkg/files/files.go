@@ -1,22 +1,27 @@
 package files
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"math"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+	"github.com/ReanSn0w/wddl/pkg/retry"
 	"github.com/go-pkgz/lgr"
 )
 
-const (
-	maxRetries    = 3
-	partitionSize = 64 << 20 // 64 MB
-)
-
 type Webdav interface {
 	ReadDir(path string) ([]os.FileInfo, error)
 	ReadStreamRange(path string, offset int64, length int64) (io.ReadCloser, error)
@@ -31,114 +36,113 @@ func New(client Webdav) *Files {
 
 type Files struct {
 	client Webdav
-}
 
-func (f *Files) Scan(conf engine.Config, inputDir string) ([]engine.File, error) {
-	files, err := f.client.ReadDir(inputDir)
-	if err != nil {
-		return nil, err
-	}
-
-	var result []engine.File
-	for _, file := range files {
-		if file.IsDir() {
-			sub, err := f.Scan(conf, inputDir+"/"+file.Name())
-			if err != nil {
-				return nil, err
-			}
-
-			result = append(result, sub...)
-		} else {
-			result = append(result, engine.NewFile(conf, inputDir+"/"+file.Name(), file.Size()))
-		}
-	}
-
-	return result, nil
+	scans  atomic.Int64 // Количество инкрементальных сканирований
+	noTree atomic.Bool  // Источник не поддерживает чтение поддерева
 }
 
-func (d *Files) Download(pch chan<- engine.Progress, file engine.File) error {
-	var lastErr error
-
-	lgr.Default().Logf("[DEBUG] download delay before starting (3 seconds)")
-	time.Sleep(time.Second * 3)
+// Download - загружает файл, повторяя неудачные попытки по conf.Retry
+//
+// Отказ в доступе и отсутствие файла не повторяются. При ограничении
+// частоты запросов задержка учитывает Retry-After
+func (d *Files) Download(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File) (string, error) {
+	var (
+		lastErr  error
+		attempts = conf.Retry.Attempts()
+	)
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err := wait(ctx, conf)
+		if err != nil {
+			return "", err
+		}
 
-	for attempt := range maxRetries {
-		lgr.Default().Logf("[DEBUG] download attempt %d/%d for file %s", attempt+1, maxRetries, file.Name)
-		err := d.download(pch, file)
+		lgr.Default().Logf("[DEBUG] download attempt %d/%d for file %s", attempt, attempts, file.Name)
+		checksum, err := d.download(ctx, conf, pch, file)
 		if err == nil {
 			lgr.Default().Logf("[INFO] download completed successfully for file %s", file.Name)
-			return nil
+			return checksum, nil
+		}
+		if errors.Is(err, engine.ErrPaused) {
+			lgr.Default().Logf("[INFO] download of file %s paused at partition boundary", file.Name)
+			return "", err
+		}
+		if ctx.Err() != nil {
+			lgr.Default().Logf("[INFO] download of file %s canceled: %v", file.Name, context.Cause(ctx))
+			return "", ctx.Err()
 		}
 		lastErr = err
-		if attempt < maxRetries-1 {
-			backoff := time.Duration(math.Pow(2, float64(attempt+1))) * time.Second
-			lgr.Default().Logf("[WARN] download attempt %d/%d failed for %s, retry in %v: %v",
-				attempt+1, maxRetries, file.ID, backoff, err)
-			time.Sleep(backoff)
+
+		class := retry.Classify(err)
+		if !class.Retryable() {
+			lgr.Default().Logf("[ERROR] download of %s failed with %s error, not retrying: %v", file.ID, class, err)
+			return "", fmt.Errorf("failed to download %s: %w", file.ID, err)
+		}
+
+		if attempt < attempts {
+			metrics.DownloadRetries.Inc()
+			delay := conf.Retry.Delay(attempt)
+			if class == retry.ClassThrottled {
+				delay = max(delay, conf.Retry.ServerDelay(retry.After(err)))
+			}
+
+			lgr.Default().Logf("[WARN] download attempt %d/%d failed for %s with %s error, retry in %v: %v",
+				attempt, attempts, file.ID, class, delay, err)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return "", ctx.Err()
+			case <-timer.C:
+			}
 		}
 	}
 
-	lgr.Default().Logf("[ERROR] download failed for %s after %d attempts: %v", file.ID, maxRetries, lastErr)
-	return fmt.Errorf("failed to download %s after %d attempts: %w", file.ID, maxRetries, lastErr)
+	lgr.Default().Logf("[ERROR] download failed for %s after %d attempts: %v", file.ID, attempts, lastErr)
+	return "", fmt.Errorf("failed to download %s after %d attempts: %w", file.ID, attempts, lastErr)
 }
 
 func (d *Files) Delete(file engine.File) error {
 	return d.client.Remove(file.Source)
 }
 
-func (f *Files) download(pch chan<- engine.Progress, file engine.File) error {
+func (f *Files) download(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File) (string, error) {
 	lgr.Default().Logf("[DEBUG] creating temp directory for file %s", file.Name)
 	err := os.MkdirAll(file.Temp, 0755)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 
 	lgr.Default().Logf("[DEBUG] checking download status for file %s", file.Name)
 	stat, err := f.currentStat(file)
 	if err != nil {
-		return fmt.Errorf("failed to get current stat: %w", err)
+		return "", fmt.Errorf("failed to get current stat: %w", err)
 	}
 
 	lgr.Default().Logf("[DEBUG] file %s progress: %d/%d partitions (%.2f%%)",
 		file.Name, stat.Done, stat.Count, stat.CompletePercent())
 
 	if !stat.IsComplete() {
-		lgr.Default().Logf("[DEBUG] starting download stream for file %s from byte %d", file.Name, stat.SkipBytes)
-		datastream, err := f.client.ReadStreamRange(file.Source, stat.SkipBytes, file.Size-stat.SkipBytes)
-		if err != nil {
-			return fmt.Errorf("failed to create read stream: %w", err)
-		}
-
-		defer datastream.Close()
-
-		pwc := &PartitionWriteCloser{
-			ProgressChan: pch,
-			File:         &file,
-			Path:         file.Temp,
-			CurrentIndex: int(stat.Done),
-		}
-
-		defer pwc.Close()
-
-		_, err = io.Copy(pwc, datastream)
+		lgr.Default().Logf("[DEBUG] downloading %d missing partitions of file %s", len(stat.Missing), file.Name)
+		err = f.downloadPartitions(ctx, conf, pch, file, stat)
 		if err != nil {
-			return fmt.Errorf("failed to copy download data: %w", err)
+			return "", fmt.Errorf("failed to download partitions: %w", err)
 		}
 
-		lgr.Default().Logf("[DEBUG] download stream completed for file %s", file.Name)
+		lgr.Default().Logf("[DEBUG] partitions download completed for file %s", file.Name)
 	} else {
 		lgr.Default().Logf("[DEBUG] file %s is already fully downloaded, skipping stream", file.Name)
 	}
 
 	lgr.Default().Logf("[DEBUG] completing file %s (merging partitions and moving to destination)", file.Name)
-	return f.completeFile(file)
+	return f.completeFile(conf, file)
 }
 
 func (f *Files) validatePartitions(file engine.File, stat *Stat) error {
 	// Verify all expected partitions exist and have correct sizes
 	for i := int64(1); i <= stat.Count; i++ {
-		partFile := fmt.Sprintf("%s/%d.part", file.Temp, i)
-		info, err := os.Stat(partFile)
+		info, err := os.Stat(partitionPath(file, i))
 		if err != nil {
 			if os.IsNotExist(err) {
 				return fmt.Errorf("partition %d missing: %w", i, err)
@@ -146,11 +150,7 @@ func (f *Files) validatePartitions(file engine.File, stat *Stat) error {
 			return fmt.Errorf("failed to stat partition %d: %w", i, err)
 		}
 
-		expectedSize := int64(partitionSize)
-		if i == stat.Count && stat.LastPartitionSize != 0 {
-			expectedSize = stat.LastPartitionSize
-		}
-
+		expectedSize := stat.SizeOf(i)
 		if info.Size() != expectedSize {
 			return fmt.Errorf("partition %d has incorrect size: expected %d, got %d", i, expectedSize, info.Size())
 		}
@@ -161,88 +161,94 @@ func (f *Files) validatePartitions(file engine.File, stat *Stat) error {
 
 func (f *Files) currentStat(file engine.File) (*Stat, error) {
 	stat := &Stat{
-		ID:       file.ID,
-		FileName: file.Name,
+		ID:            file.ID,
+		FileName:      file.Name,
+		PartitionSize: file.PartitionSize,
+	}
+
+	// Files queued before partition size became configurable
+	// were split with the default size
+	if stat.PartitionSize <= 0 {
+		stat.PartitionSize = engine.DefaultPartitionSize
 	}
 
-	stat.Count = file.Size / partitionSize
-	if lastSize := file.Size % partitionSize; lastSize != 0 {
+	stat.Count = file.Size / stat.PartitionSize
+	if lastSize := file.Size % stat.PartitionSize; lastSize != 0 {
 		stat.Count++
 		stat.LastPartitionSize = lastSize
 	}
 
-	// Count complete partitions sequentially from the beginning
+	// Check every partition, so holes left after a restart can be filled
 	for i := int64(1); i <= stat.Count; i++ {
-		partFile := fmt.Sprintf("%s/%d.part", file.Temp, i)
-		info, err := os.Stat(partFile)
+		info, err := os.Stat(partitionPath(file, i))
 		if err != nil {
 			if os.IsNotExist(err) {
-				// Partition doesn't exist, stop counting
-				break
+				stat.Missing = append(stat.Missing, i)
+				continue
 			}
 			return nil, err
 		}
 
-		// Validate partition size
-		expectedSize := int64(partitionSize)
-		if i == stat.Count && stat.LastPartitionSize != 0 {
-			expectedSize = stat.LastPartitionSize
-		}
-
-		if info.Size() != expectedSize {
-			// Partition has incorrect size, stop counting
-			break
+		// Partition with incorrect size must be downloaded again
+		if info.Size() != stat.SizeOf(i) {
+			stat.Missing = append(stat.Missing, i)
+			continue
 		}
 
 		stat.Done++
 	}
 
-	if !stat.IsComplete() {
-		stat.SkipBytes = stat.Done * partitionSize
-	}
-
 	return stat, nil
 }
 
-func (f *Files) completeFile(file engine.File) error {
+// completeFile - объединяет части в итоговый файл, перемещает его
+// в место назначения и возвращает его контрольную сумму (sha256)
+func (f *Files) completeFile(conf engine.Config, file engine.File) (string, error) {
 	// First, get current stat and validate all partitions exist
 	stat, err := f.currentStat(file)
 	if err != nil {
-		return fmt.Errorf("failed to get partition stat: %w", err)
+		return "", fmt.Errorf("failed to get partition stat: %w", err)
 	}
 
 	if !stat.IsComplete() {
-		return fmt.Errorf("download incomplete: %d/%d partitions", stat.Done, stat.Count)
+		return "", fmt.Errorf("download incomplete: %d/%d partitions", stat.Done, stat.Count)
 	}
 
 	// Validate all expected partitions exist and have correct sizes
 	if err := f.validatePartitions(file, stat); err != nil {
-		return fmt.Errorf("partition validation failed: %w", err)
+		return "", fmt.Errorf("partition validation failed: %w", err)
 	}
 
 	// Create a temporary merge file first (in temp directory, then move)
 	tempMergeFile := file.Temp + "/" + file.Name + ".merging"
 	resultFile, err := os.Create(tempMergeFile)
 	if err != nil {
-		return fmt.Errorf("failed to create merge file: %w", err)
+		return "", fmt.Errorf("failed to create merge file: %w", err)
+	}
+
+	// Merge all partitions in order, hashing the merged data
+	hash := sha256.New()
+	writer := io.MultiWriter(resultFile, hash)
+
+	expected := f.expectedChecksum(conf, file)
+	if expected != nil {
+		writer = io.MultiWriter(writer, expected.hash)
 	}
 
-	// Merge all partitions in order
 	for i := int64(1); i <= stat.Count; i++ {
-		partFile := fmt.Sprintf("%s/%d.part", file.Temp, i)
-		pf, err := os.Open(partFile)
+		pf, err := os.Open(partitionPath(file, i))
 		if err != nil {
 			resultFile.Close()
 			os.Remove(tempMergeFile)
-			return fmt.Errorf("failed to open partition %d: %w", i, err)
+			return "", fmt.Errorf("failed to open partition %d: %w", i, err)
 		}
 
-		_, err = io.Copy(resultFile, pf)
+		_, err = io.Copy(writer, pf)
 		pf.Close()
 		if err != nil {
 			resultFile.Close()
 			os.Remove(tempMergeFile)
-			return fmt.Errorf("failed to copy partition %d: %w", i, err)
+			return "", fmt.Errorf("failed to copy partition %d: %w", i, err)
 		}
 	}
 
@@ -250,46 +256,89 @@ func (f *Files) completeFile(file engine.File) error {
 	if err := resultFile.Sync(); err != nil {
 		resultFile.Close()
 		os.Remove(tempMergeFile)
-		return fmt.Errorf("failed to sync merged file: %w", err)
+		return "", fmt.Errorf("failed to sync merged file: %w", err)
 	}
 
 	if err := resultFile.Close(); err != nil {
 		os.Remove(tempMergeFile)
-		return fmt.Errorf("failed to close merged file: %w", err)
+		return "", fmt.Errorf("failed to close merged file: %w", err)
 	}
 
 	// Verify merged file size matches expected size
 	info, err := os.Stat(tempMergeFile)
 	if err != nil {
 		os.Remove(tempMergeFile)
-		return fmt.Errorf("failed to stat merged file: %w", err)
+		return "", fmt.Errorf("failed to stat merged file: %w", err)
 	}
 
 	if info.Size() != file.Size {
 		os.Remove(tempMergeFile)
-		return fmt.Errorf("merged file size mismatch: expected %d, got %d", file.Size, info.Size())
+		return "", fmt.Errorf("merged file size mismatch: expected %d, got %d", file.Size, info.Size())
+	}
+
+	// Partitions hold corrupted data, so they are removed
+	// to download the file from scratch on the next attempt
+	if expected != nil {
+		if actual := hex.EncodeToString(expected.hash.Sum(nil)); actual != expected.value {
+			os.RemoveAll(file.Temp)
+			return "", fmt.Errorf("%w: expected %s:%s, got %s:%s",
+				engine.ErrChecksumMismatch, expected.algo, expected.value, expected.algo, actual)
+		}
+
+		lgr.Default().Logf("[DEBUG] file %s checksum verified (%s)", file.Name, expected.algo)
 	}
 
 	// Move to final destination
 	err = os.MkdirAll(filepath.Dir(file.Dest), 0755)
 	if err != nil {
 		os.Remove(tempMergeFile)
-		return fmt.Errorf("failed to create destination directory: %w", err)
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
 	err = f.moveFile(tempMergeFile, file.Dest)
 	if err != nil {
 		os.Remove(tempMergeFile)
-		return fmt.Errorf("failed to move file to destination: %w", err)
+		return "", fmt.Errorf("failed to move file to destination: %w", err)
 	}
 
 	// Clean up temporary directory
 	err = os.RemoveAll(file.Temp)
 	if err != nil {
-		return fmt.Errorf("failed to remove temp directory: %w", err)
+		return "", fmt.Errorf("failed to remove temp directory: %w", err)
 	}
 
-	return nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type checksum struct {
+	algo  string
+	value string
+	hash  hash.Hash
+}
+
+// expectedChecksum - возвращает ожидаемую контрольную сумму файла
+// или nil, если проверка отключена или невозможна
+func (f *Files) expectedChecksum(conf engine.Config, file engine.File) *checksum {
+	if !conf.VerifyChecksum || file.Checksum == "" {
+		return nil
+	}
+
+	algo, value, _ := strings.Cut(file.Checksum, ":")
+	result := &checksum{algo: algo, value: strings.ToLower(value)}
+
+	switch algo {
+	case "md5":
+		result.hash = md5.New()
+	case "sha1":
+		result.hash = sha1.New()
+	case "sha256":
+		result.hash = sha256.New()
+	default:
+		lgr.Default().Logf("[WARN] unsupported checksum %s for file %s, skipping verification", algo, file.Name)
+		return nil
+	}
+
+	return result
 }
 
 func (f *Files) moveFile(src, dst string) error {
@@ -329,3 +378,29 @@ func (f *Files) moveFile(src, dst string) error {
 
 	return nil
 }
+
+// wait - ожидает окончания общей паузы запросов к удаленному
+// хранилищу или отмены ctx
+func wait(ctx context.Context, conf engine.Config) error {
+	if conf.Breaker == nil {
+		return ctx.Err()
+	}
+
+	return conf.Breaker.Wait(ctx)
+}
+
+// tripOnThrottle - приостанавливает запросы всех воркеров,
+// если сервер ограничил частоту запросов
+func tripOnThrottle(conf engine.Config, err error) {
+	if retry.Classify(err) != retry.ClassThrottled {
+		return
+	}
+
+	metrics.Throttled.Inc()
+	delay := max(conf.Retry.Delay(1), conf.Retry.ServerDelay(retry.After(err)))
+
+	if conf.Breaker != nil {
+		lgr.Default().Logf("[WARN] server is throttling requests, pausing for %v: %v", delay, err)
+		conf.Breaker.Trip(delay)
+	}
+}
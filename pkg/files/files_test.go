@@ -0,0 +1,314 @@
+package files_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/davtest"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/filter"
+	"github.com/ReanSn0w/wddl/pkg/limiter"
+)
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+
+	return data
+}
+
+func testConfig(t *testing.T) engine.Config {
+	dir := t.TempDir()
+
+	return engine.Config{
+		InputPath:        "/input",
+		OutputPath:       dir + "/output",
+		TempPath:         dir + "/temp",
+		PartitionThreads: 2,
+		PartitionSize:    1 << 20,
+	}
+}
+
+func newFiles(srv *davtest.Server) *files.Files {
+	return files.New(srv.Client())
+}
+
+func TestScan(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	for name, size := range map[string]int{
+		"/input/a.bin":       10,
+		"/input/sub/b.bin":   20,
+		"/input/sub/c.tmp":   30,
+		"/input/cache/d.bin": 40,
+		"/other/e.bin":       50,
+	} {
+		if err := srv.WriteFile(name, testData(size)); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	conf := testConfig(t)
+
+	var err error
+	conf.Filter, err = filter.New(filter.Options{
+		Exclude:     []string{"*.tmp"},
+		ExcludeDirs: []string{"cache"},
+	})
+	if err != nil {
+		t.Fatalf("filter.New() error = %v", err)
+	}
+
+	result, err := newFiles(srv).Scan(context.Background(), conf, conf.InputPath)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	found := make(map[string]engine.File)
+	for _, file := range result {
+		found[file.Source] = file
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("Scan() found %v, want 2 files", found)
+	}
+
+	b, ok := found["/input/sub/b.bin"]
+	if !ok {
+		t.Fatalf("Scan() did not find /input/sub/b.bin")
+	}
+
+	if b.Size != 20 || b.Dest != conf.OutputPath+"/sub/b.bin" || b.ModTime.IsZero() {
+		t.Errorf("unexpected file %+v", b)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := testData(5<<19 + 123) // 2.5 MB, три части
+
+	tests := []struct {
+		name    string
+		faults  []davtest.Fault
+		wantErr bool
+	}{
+		{
+			name: "Without faults",
+		},
+		{
+			name:   "Connection dropped mid-range",
+			faults: []davtest.Fault{{Kind: davtest.FaultDrop, Method: http.MethodGet, Bytes: 1000}},
+		},
+		{
+			name:   "Truncated response",
+			faults: []davtest.Fault{{Kind: davtest.FaultTruncate, Method: http.MethodGet, Bytes: 1000}},
+		},
+		{
+			name:   "Slow response",
+			faults: []davtest.Fault{{Kind: davtest.FaultDelay, Method: http.MethodGet, Delay: 300 * time.Millisecond}},
+		},
+		{
+			name:   "Server error",
+			faults: []davtest.Fault{{Kind: davtest.FaultStatus, Method: http.MethodGet, Status: http.StatusBadGateway}},
+		},
+		{
+			name:   "Throttled",
+			faults: []davtest.Fault{{Kind: davtest.FaultStatus, Method: http.MethodGet, Status: http.StatusTooManyRequests, RetryAfter: time.Second}},
+		},
+		{
+			name:    "Unauthorized is not retried",
+			faults:  []davtest.Fault{{Kind: davtest.FaultStatus, Method: http.MethodGet, Status: http.StatusUnauthorized}},
+			wantErr: true,
+		},
+		{
+			name:    "Permanent server error",
+			faults:  []davtest.Fault{{Kind: davtest.FaultStatus, Method: http.MethodGet, Status: http.StatusInternalServerError, Times: 100}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := davtest.NewServer()
+			defer srv.Close()
+
+			if err := srv.WriteFile("/input/dir/file.bin", data); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			client := newFiles(srv)
+			for _, fault := range tt.faults {
+				srv.Inject(fault)
+			}
+
+			conf := testConfig(t)
+			file := engine.NewFile(conf, "/input/dir/file.bin", int64(len(data)))
+
+			pch := make(chan engine.Progress, 100)
+			checksum, err := client.Download(context.Background(), conf, pch, file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if _, err := os.Stat(file.Dest); !os.IsNotExist(err) {
+					t.Errorf("destination should not exist after failed download")
+				}
+				return
+			}
+
+			got, err := os.ReadFile(file.Dest)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+
+			if !bytes.Equal(got, data) {
+				t.Errorf("downloaded data mismatch")
+			}
+
+			if checksum == "" {
+				t.Errorf("Download() returned empty checksum")
+			}
+
+			if _, err := os.Stat(file.Temp); !os.IsNotExist(err) {
+				t.Errorf("temp directory should be removed after download")
+			}
+		})
+	}
+}
+
+func TestDownloadThrottled(t *testing.T) {
+	data := testData(5<<19 + 123)
+
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.bin", data); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	srv.Inject(davtest.Fault{Kind: davtest.FaultStatus, Method: http.MethodGet, Status: http.StatusServiceUnavailable, RetryAfter: time.Second})
+
+	conf := testConfig(t)
+	conf.Retry = engine.RetryPolicy{Base: 10 * time.Millisecond}
+	conf.Breaker = limiter.NewBreaker()
+
+	started := time.Now()
+	_, err := newFiles(srv).Download(context.Background(), conf, nil, engine.NewFile(conf, "/input/file.bin", int64(len(data))))
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	// Повтор выполняется не раньше, чем просил сервер
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("Download() retried after %v, want at least Retry-After", elapsed)
+	}
+
+	if until := conf.Breaker.(*limiter.Breaker).Until(); until.Before(started.Add(time.Second)) {
+		t.Errorf("breaker paused until %v, want at least %v", until, started.Add(time.Second))
+	}
+}
+
+func TestDownloadThrottledLimits(t *testing.T) {
+	data := testData(100)
+
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.bin", data); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	srv.Inject(davtest.Fault{Kind: davtest.FaultStatus, Method: http.MethodGet, Status: http.StatusTooManyRequests, RetryAfter: 24 * time.Hour})
+
+	conf := testConfig(t)
+	conf.Retry = engine.RetryPolicy{Base: 10 * time.Millisecond, Cap: 200 * time.Millisecond}
+	conf.Breaker = limiter.NewBreaker()
+	file := engine.NewFile(conf, "/input/file.bin", int64(len(data)))
+
+	// Задержка сервера ограничена Cap
+	started := time.Now()
+	if _, err := newFiles(srv).Download(context.Background(), conf, nil, file); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Download() took %v, want Retry-After limited by Cap", elapsed)
+	}
+
+	// Ожидание паузы прерывается отменой контекста
+	conf.Breaker.Trip(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started = time.Now()
+	if _, err := newFiles(srv).Download(ctx, conf, nil, file); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Download() error = %v, want context deadline", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Download() returned after %v, want prompt cancellation", elapsed)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.bin", testData(10)); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	conf := testConfig(t)
+	err := newFiles(srv).Delete(engine.NewFile(conf, "/input/file.bin", 10))
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if srv.Exists("/input/file.bin") {
+		t.Errorf("remote file should be deleted")
+	}
+}
+
+// closedWindow - окно загрузки, которое никогда не открывается
+type closedWindow struct{}
+
+func (closedWindow) Active(time.Time) bool { return false }
+
+func TestDownloadPaused(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.bin", testData(3<<20)); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	conf := testConfig(t)
+	conf.Window = closedWindow{}
+	conf.PauseInFlight = true
+
+	file := engine.NewFile(conf, "/input/file.bin", 3<<20)
+	_, err := newFiles(srv).Download(context.Background(), conf, nil, file)
+	if !errors.Is(err, engine.ErrPaused) {
+		t.Fatalf("Download() error = %v, want %v", err, engine.ErrPaused)
+	}
+
+	if n := srv.Requests(http.MethodGet); n != 0 {
+		t.Errorf("no partitions should be requested, got %d requests", n)
+	}
+
+	if _, err := os.Stat(file.Dest); !os.IsNotExist(err) {
+		t.Errorf("destination file should not exist")
+	}
+}
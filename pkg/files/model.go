@@ -3,9 +3,10 @@ package files
 type Stat struct {
 	ID                string
 	FileName          string
+	PartitionSize     int64
 	Count             int64
 	Done              int64
-	SkipBytes         int64
+	Missing           []int64
 	LastPartitionSize int64
 }
 
@@ -27,3 +28,18 @@ func (ps *Stat) CompletePercent() float64 {
 func (ps *Stat) IsComplete() bool {
 	return ps.Done == ps.Count
 }
+
+// SizeOf - возвращает ожидаемый размер части с указанным индексом
+func (ps *Stat) SizeOf(index int64) int64 {
+	if index == ps.Count && ps.LastPartitionSize != 0 {
+		return ps.LastPartitionSize
+	}
+
+	return ps.PartitionSize
+}
+
+// OffsetOf - возвращает смещение части с указанным индексом
+// относительно начала файла
+func (ps *Stat) OffsetOf(index int64) int64 {
+	return (index - 1) * ps.PartitionSize
+}
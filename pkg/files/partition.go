@@ -1,124 +1,180 @@
 package files
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+	"github.com/go-pkgz/lgr"
 )
 
-type PartitionWriteCloser struct {
-	ProgressChan chan<- engine.Progress
-	File         *engine.File
-
-	Path         string
-	CurrentIndex int
-
-	writedBytes   int64
-	currentPart   *os.File
-	lastSplitTime time.Time
+// partitionPath - возвращает путь к файлу части с указанным индексом
+func partitionPath(file engine.File, index int64) string {
+	return fmt.Sprintf("%s/%d.part", file.Temp, index)
 }
 
-func (p *PartitionWriteCloser) CurrentPart() *os.File {
-	return p.currentPart
-}
+// downloadPartitions - загружает недостающие части файла
+// в несколько потоков, каждая часть загружается отдельным запросом
+func (f *Files) downloadPartitions(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File, stat *Stat) error {
+	threads := conf.PartitionThreads
+	if threads < 1 {
+		threads = 1
+	}
 
-func (p *PartitionWriteCloser) WritedBytes() int64 {
-	return p.writedBytes
-}
+	if threads > len(stat.Missing) {
+		threads = len(stat.Missing)
+	}
 
-func (p *PartitionWriteCloser) Write(data []byte) (int, error) {
-	totalWritten := 0
-	dataToWrite := data
+	limit := func(r io.Reader) io.Reader { return r }
+	if conf.Throttle != nil {
+		limit = conf.Throttle.Limit(ctx)
+	}
 
-	for len(dataToWrite) > 0 {
-		// Создаем новую часть если нужна
-		if p.currentPart == nil {
-			if err := p.makePartition(); err != nil {
-				return totalWritten, err
+	var (
+		wg         sync.WaitGroup
+		once       sync.Once
+		lastErr    error
+		failed     atomic.Bool
+		done       = stat.Done
+		downloaded int64
+		started    = time.Now()
+		parts      = make(chan int64)
+	)
+
+	for range threads {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for index := range parts {
+				if failed.Load() {
+					continue
+				}
+
+				// Начатые части догружаются, новые не запрашиваются
+				if conf.Interrupted(time.Now()) {
+					once.Do(func() { lastErr = engine.ErrPaused })
+					failed.Store(true)
+					continue
+				}
+
+				if ctx.Err() != nil {
+					once.Do(func() { lastErr = ctx.Err() })
+					failed.Store(true)
+					continue
+				}
+
+				if err := wait(ctx, conf); err != nil {
+					once.Do(func() { lastErr = err })
+					failed.Store(true)
+					continue
+				}
+
+				err := f.downloadPartition(ctx, file, stat, index, limit)
+				if err != nil {
+					tripOnThrottle(conf, err)
+					once.Do(func() { lastErr = err })
+					failed.Store(true)
+					continue
+				}
+
+				progress := makeProgress(file, stat,
+					atomic.AddInt64(&done, 1),
+					atomic.AddInt64(&downloaded, stat.SizeOf(index)),
+					time.Since(started))
+
+				// Прогресс не читается после остановки движка,
+				// ожидание отправки не должно блокировать воркер
+				if pch != nil {
+					select {
+					case pch <- progress:
+					case <-ctx.Done():
+					}
+				}
 			}
+		}()
+	}
+
+	for _, index := range stat.Missing {
+		if failed.Load() {
+			break
 		}
 
-		// Сколько байт можем записать в текущую часть
-		remaining := partitionSize - p.writedBytes
-		toWrite := int64(len(dataToWrite))
+		parts <- index
+	}
 
-		if toWrite > remaining {
-			toWrite = remaining
-		}
+	close(parts)
+	wg.Wait()
 
-		// Записываем порцию данных
-		n, err := p.currentPart.Write(dataToWrite[:toWrite])
-		if err != nil {
-			return totalWritten + n, err
-		}
+	return lastErr
+}
 
-		p.writedBytes += int64(n)
-		totalWritten += n
-		dataToWrite = dataToWrite[toWrite:]
+// downloadPartition - загружает одну часть файла
+//
+// Часть, загруженная не полностью, будет иметь неверный размер
+// и при следующей попытке будет загружена заново. Отмена ctx
+// закрывает поток, прерывая загрузку части
+func (f *Files) downloadPartition(ctx context.Context, file engine.File, stat *Stat, index int64, limit func(io.Reader) io.Reader) error {
+	size := stat.SizeOf(index)
 
-		// Если текущая часть полная, закрываем её и синхронизируем
-		if p.writedBytes == partitionSize {
-			// Sync to ensure data is written to disk
-			if err := p.currentPart.Sync(); err != nil {
-				p.currentPart.Close()
-				return totalWritten, err
-			}
-			if err := p.currentPart.Close(); err != nil {
-				return totalWritten, err
-			}
-			p.currentPart = nil
-		}
+	lgr.Default().Logf("[DEBUG] downloading partition %d/%d of file %s", index, stat.Count, file.Name)
+	stream, err := f.client.ReadStreamRange(file.Source, stat.OffsetOf(index), size)
+	if err != nil {
+		return fmt.Errorf("failed to create read stream for partition %d: %w", index, err)
 	}
 
-	return totalWritten, nil
-}
+	// Поток закрывается один раз, даже если закрытие вызвано отменой
+	closeStream := sync.OnceValue(stream.Close)
+	defer closeStream()
 
-func (p *PartitionWriteCloser) Close() error {
-	if p.currentPart == nil {
-		return nil
-	}
+	stop := context.AfterFunc(ctx, func() { closeStream() })
+	defer stop()
 
-	// Sync to ensure data is written to disk before closing
-	if err := p.currentPart.Sync(); err != nil {
-		p.currentPart.Close()
-		return err
+	part, err := os.Create(partitionPath(file, index))
+	if err != nil {
+		return fmt.Errorf("failed to create partition %d: %w", index, err)
 	}
 
-	err := p.currentPart.Close()
+	n, err := io.Copy(part, limit(io.LimitReader(stream, size)))
+	metrics.DownloadedBytes.Add(n)
 	if err != nil {
-		return err
+		part.Close()
+		return fmt.Errorf("failed to copy partition %d data: %w", index, err)
 	}
 
-	return nil
-}
-
-func (p *PartitionWriteCloser) makeProgress() engine.Progress {
-	downloaded := (p.CurrentIndex - 1) * partitionSize
-	fullSize := p.File.Size
-
-	duration := time.Since(p.lastSplitTime)
-	seconds := duration / time.Second
+	if n != size {
+		part.Close()
+		return fmt.Errorf("partition %d is incomplete: expected %d, got %d", index, size, n)
+	}
 
-	return engine.Progress{
-		ID:      p.File.ID,
-		Name:    p.File.Name,
-		Percent: float64(downloaded) / float64(fullSize) * 100,
-		Speed:   partitionSize / int64(seconds),
+	// Sync to ensure data is written to disk
+	if err := part.Sync(); err != nil {
+		part.Close()
+		return fmt.Errorf("failed to sync partition %d: %w", index, err)
 	}
-}
 
-func (p *PartitionWriteCloser) makePartition() (err error) {
-	p.CurrentIndex++
-	p.writedBytes = 0
+	return part.Close()
+}
 
-	if !p.lastSplitTime.IsZero() {
-		p.ProgressChan <- p.makeProgress()
+// makeProgress - формирует прогресс загрузки файла, скорость считается
+// по всем частям загруженным в рамках текущей попытки
+func makeProgress(file engine.File, stat *Stat, done, downloaded int64, duration time.Duration) engine.Progress {
+	progress := engine.Progress{
+		ID:      file.ID,
+		Name:    file.Name,
+		Percent: float64(done) / float64(stat.Count) * 100,
 	}
 
-	p.lastSplitTime = time.Now()
+	if seconds := duration.Seconds(); seconds > 0 {
+		progress.Speed = int64(float64(downloaded) / seconds)
+	}
 
-	p.currentPart, err = os.Create(fmt.Sprintf("%s/%d.part", p.Path, p.CurrentIndex))
-	return err
+	return progress
 }
@@ -0,0 +1,156 @@
+package files_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/davtest"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+)
+
+func TestStatLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		stat    files.Stat
+		sizes   []int64
+		offsets []int64
+	}{
+		{
+			name:    "Even split",
+			stat:    files.Stat{PartitionSize: 100, Count: 3},
+			sizes:   []int64{100, 100, 100},
+			offsets: []int64{0, 100, 200},
+		},
+		{
+			name:    "Short last partition",
+			stat:    files.Stat{PartitionSize: 100, Count: 3, LastPartitionSize: 42},
+			sizes:   []int64{100, 100, 42},
+			offsets: []int64{0, 100, 200},
+		},
+		{
+			name:    "File smaller than partition",
+			stat:    files.Stat{PartitionSize: 100, Count: 1, LastPartitionSize: 7},
+			sizes:   []int64{7},
+			offsets: []int64{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.stat.Count {
+				index := i + 1
+
+				if got := tt.stat.SizeOf(index); got != tt.sizes[i] {
+					t.Errorf("SizeOf(%d) = %d, want %d", index, got, tt.sizes[i])
+				}
+
+				if got := tt.stat.OffsetOf(index); got != tt.offsets[i] {
+					t.Errorf("OffsetOf(%d) = %d, want %d", index, got, tt.offsets[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	const partition = 1000
+
+	data := testData(partition*3 + 500) // четыре части, последняя короче
+
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.bin", data); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	conf := testConfig(t)
+	conf.PartitionSize = partition
+
+	file := engine.NewFile(conf, "/input/file.bin", int64(len(data)))
+	if err := os.MkdirAll(file.Temp, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	// Части 1 и 3 загружены до перезапуска, часть 2 отсутствует,
+	// а последняя часть оборвана и должна быть загружена заново
+	parts := map[int][]byte{
+		1: data[:partition],
+		3: data[partition*2 : partition*3],
+		4: data[partition*3 : partition*3+100],
+	}
+
+	for index, part := range parts {
+		name := fmt.Sprintf("%s/%d.part", file.Temp, index)
+		if err := os.WriteFile(name, part, 0644); err != nil {
+			t.Fatalf("WriteFile(%s) error = %v", name, err)
+		}
+	}
+
+	before := srv.Requests(http.MethodGet)
+
+	_, err := newFiles(srv).Download(context.Background(), conf, make(chan engine.Progress, 100), file)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if got := srv.Requests(http.MethodGet) - before; got != 2 {
+		t.Errorf("Download() made %d range requests, want 2 for missing partitions", got)
+	}
+
+	got, err := os.ReadFile(file.Dest)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("resumed download data mismatch")
+	}
+}
+
+func TestDownloadProgressNotRead(t *testing.T) {
+	data := testData(4000)
+
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/input/file.bin", data); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	conf := testConfig(t)
+	conf.PartitionSize = 1000
+
+	file := engine.NewFile(conf, "/input/file.bin", int64(len(data)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Прогресс никто не читает, как после остановки движка
+	pch := make(chan engine.Progress)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := newFiles(srv).Download(ctx, conf, pch, file)
+		result <- err
+	}()
+
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Download() error = %v, want context canceled", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Download() blocked on progress after cancel")
+	}
+}
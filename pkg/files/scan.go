@@ -0,0 +1,352 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+	"github.com/ReanSn0w/wddl/pkg/retry"
+	"github.com/ReanSn0w/wddl/pkg/walk"
+	"github.com/go-pkgz/lgr"
+)
+
+// Tree - источник, возвращающий содержимое всех вложенных
+// директорий одним запросом (PROPFIND с Depth: infinity)
+type Tree interface {
+	ReadTree(dir string) (map[string][]os.FileInfo, error)
+}
+
+// Scan - возвращает файлы директории inputDir и всех вложенных директорий
+//
+// Директории читаются параллельно в conf.ScanThreads потоков не глубже
+// conf.MaxDepth. Ошибки чтения вложенных директорий не прерывают
+// сканирование, а возвращаются в *engine.ScanError вместе с найденными файлами.
+// Если задан conf.ScanCache, директории, версия которых не изменилась
+// с прошлого сканирования, не читаются, а их содержимое берется из кэша.
+// Отмена ctx прерывает сканирование, кэш при этом не сохраняется
+func (f *Files) Scan(ctx context.Context, conf engine.Config, inputDir string) ([]engine.File, error) {
+	s := &scan{
+		ctx:   ctx,
+		files: f,
+		conf:  conf,
+		tree:  make(map[string][]engine.DirEntry),
+		save:  make(map[string]*engine.DirState),
+	}
+
+	if conf.ScanCache != nil && conf.FullScanEvery > 0 {
+		s.full = f.scans.Add(1)%int64(conf.FullScanEvery) == 0
+	}
+
+	root := node{path: inputDir}
+	errs := walk.Walk(ctx, root, conf.ScanThreads, conf.MaxDepth, s.visit)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if err, ok := errs[root]; ok {
+		return nil, err
+	}
+
+	// Порядок файлов не зависит от порядка чтения директорий
+	slices.SortFunc(s.result, func(a, b engine.File) int {
+		return strings.Compare(a.Source, b.Source)
+	})
+
+	if conf.ScanCache != nil {
+		lgr.Default().Logf("[DEBUG] scan of %s: %d directories read, %d taken from cache", inputDir, s.read, s.cached)
+
+		err := conf.ScanCache.SaveDirs(s.save)
+		if err != nil {
+			// Следующее сканирование прочитает эти директории заново
+			lgr.Default().Logf("[WARN] failed to save scan cache: %v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		partial := &engine.ScanError{Dirs: make(map[string]error, len(errs))}
+		for dir, err := range errs {
+			partial.Dirs[dir.path] = err
+		}
+
+		return s.result, partial
+	}
+
+	return s.result, nil
+}
+
+// node - директория обхода
+type node struct {
+	path    string
+	version string // "" - неизвестна
+}
+
+// scan - состояние одного сканирования
+type scan struct {
+	ctx   context.Context
+	files *Files
+	conf  engine.Config
+
+	// Читать все директории независимо от версий
+	full bool
+
+	mx     sync.Mutex
+	result []engine.File
+
+	// Директории, прочитанные запросом всего поддерева
+	tree map[string][]engine.DirEntry
+
+	// Новые состояния директорий для кэша
+	save map[string]*engine.DirState
+
+	read   int
+	cached int
+}
+
+// visit - добавляет файлы директории в результат и возвращает вложенные директории
+func (s *scan) visit(dir node) ([]node, error) {
+	entries, err := s.entries(dir.path, dir.version)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		files []engine.File
+		dirs  []node
+	)
+
+	for _, entry := range entries {
+		source := dir.path + "/" + entry.Name
+		relative := strings.TrimPrefix(source, s.conf.InputPath)
+
+		if entry.Dir {
+			if s.conf.Filter != nil && s.conf.Filter.SkipDir(relative) {
+				lgr.Default().Logf("[DEBUG] directory %s skipped by filter", relative)
+				continue
+			}
+
+			dirs = append(dirs, node{path: source, version: entry.Version})
+		} else {
+			if s.conf.Filter != nil && s.conf.Filter.Skip(relative, entry.Size) {
+				lgr.Default().Logf("[DEBUG] file %s skipped by filter", relative)
+				continue
+			}
+
+			item := engine.NewFile(s.conf, source, entry.Size)
+			item.ModTime = entry.ModTime
+			item.Checksum = entry.Checksum
+			files = append(files, item)
+		}
+	}
+
+	s.mx.Lock()
+	s.result = append(s.result, files...)
+	s.mx.Unlock()
+
+	return dirs, nil
+}
+
+// entries - возвращает содержимое директории из кэша, если ее версия
+// не изменилась, иначе читает директорию из источника
+func (s *scan) entries(dir string, version string) ([]engine.DirEntry, error) {
+	cache := s.conf.ScanCache
+	if cache == nil {
+		return s.readDir(dir)
+	}
+
+	key := path.Clean(dir)
+
+	s.mx.Lock()
+	entries, ok := s.tree[key]
+	s.mx.Unlock()
+
+	if ok {
+		return entries, nil
+	}
+
+	state, err := cache.ScannedDir(key)
+	if err != nil {
+		lgr.Default().Logf("[WARN] failed to read scan cache of %s: %v", dir, err)
+		state = nil
+	}
+
+	if state != nil && !s.full && version != "" && state.Version == version {
+		s.mx.Lock()
+		s.cached++
+		s.mx.Unlock()
+
+		metrics.ScanDirsCached.Inc()
+		return state.Entries, nil
+	}
+
+	// Пропускать в поддереве нечего, поэтому оно читается одним запросом
+	if (state == nil || s.full) && s.readTree(dir, version) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		return s.tree[key], nil
+	}
+
+	entries, err = s.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mx.Lock()
+	s.remember(key, version, entries, state)
+	s.mx.Unlock()
+
+	return entries, nil
+}
+
+// readDir - читает директорию из источника
+func (s *scan) readDir(dir string) ([]engine.DirEntry, error) {
+	err := wait(s.ctx, s.conf)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.files.client.ReadDir(dir)
+	if err != nil {
+		tripOnThrottle(s.conf, err)
+		return nil, err
+	}
+
+	s.mx.Lock()
+	s.read++
+	s.mx.Unlock()
+
+	metrics.ScanDirsRead.Inc()
+	return dirEntries(files), nil
+}
+
+// readTree - читает поддерево директории одним запросом,
+// если источник это поддерживает
+//
+// При ограничении глубины поддерево читается по директориям,
+// чтобы не запрашивать содержимое, которое не будет просканировано
+func (s *scan) readTree(dir string, version string) bool {
+	tree, ok := s.files.client.(Tree)
+	if !ok || s.files.noTree.Load() || s.conf.MaxDepth > 0 {
+		return false
+	}
+
+	if wait(s.ctx, s.conf) != nil {
+		return false
+	}
+
+	listing, err := tree.ReadTree(dir)
+	if err != nil {
+		// Отказ из-за ограничения частоты запросов не означает,
+		// что сервер не поддерживает чтение поддерева
+		if retry.Classify(err) == retry.ClassThrottled {
+			tripOnThrottle(s.conf, err)
+			return false
+		}
+
+		s.files.noTree.Store(true)
+		lgr.Default().Logf("[WARN] recursive listing of %s failed, falling back to reading directories one by one: %v", dir, err)
+		return false
+	}
+
+	previous := make(map[string]*engine.DirState, len(listing))
+	for key := range listing {
+		previous[key], err = s.conf.ScanCache.ScannedDir(key)
+		if err != nil {
+			previous[key] = nil
+		}
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	versions := map[string]string{path.Clean(dir): version}
+	for key, files := range listing {
+		entries := dirEntries(files)
+		for _, entry := range entries {
+			if entry.Dir {
+				versions[path.Join(key, entry.Name)] = entry.Version
+			}
+		}
+
+		s.tree[key] = entries
+	}
+
+	for key := range listing {
+		s.remember(key, versions[key], s.tree[key], previous[key])
+	}
+
+	s.read += len(listing)
+	metrics.ScanDirsRead.Add(int64(len(listing)))
+	return true
+}
+
+// remember - запоминает новое состояние директории, состояния
+// исчезнувших из нее вложенных директорий удаляются
+//
+// Вызывается под s.mx
+func (s *scan) remember(key, version string, entries []engine.DirEntry, previous *engine.DirState) {
+	s.save[key] = &engine.DirState{Version: version, Entries: entries}
+	if previous == nil {
+		return
+	}
+
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.Dir {
+			present[entry.Name] = true
+		}
+	}
+
+	for _, entry := range previous.Entries {
+		if entry.Dir && !present[entry.Name] {
+			s.save[path.Join(key, entry.Name)] = nil
+		}
+	}
+}
+
+// dirEntries - преобразует содержимое директории для кэша
+func dirEntries(files []os.FileInfo) []engine.DirEntry {
+	result := make([]engine.DirEntry, 0, len(files))
+	for _, file := range files {
+		entry := engine.DirEntry{
+			Name:    file.Name(),
+			Dir:     file.IsDir(),
+			Size:    file.Size(),
+			ModTime: file.ModTime(),
+		}
+
+		if entry.Dir {
+			entry.Version = dirVersion(file)
+		} else if c, ok := file.(interface{ Checksum() string }); ok {
+			entry.Checksum = c.Checksum()
+		}
+
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+// dirVersion - возвращает версию директории: ctag, etag
+// или время изменения, "" если источник их не предоставляет
+func dirVersion(file os.FileInfo) string {
+	if c, ok := file.(interface{ CTag() string }); ok && c.CTag() != "" {
+		return "ctag:" + c.CTag()
+	}
+
+	if e, ok := file.(interface{ ETag() string }); ok && e.ETag() != "" {
+		return "etag:" + e.ETag()
+	}
+
+	if !file.ModTime().IsZero() {
+		return "mtime:" + file.ModTime().UTC().Format(time.RFC3339Nano)
+	}
+
+	return ""
+}
@@ -0,0 +1,281 @@
+package files_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+)
+
+// treeInfo - элемент дерева с etag, как у Nextcloud
+type treeInfo struct {
+	name string
+	size int64
+	dir  bool
+	etag string
+}
+
+func (i *treeInfo) Name() string       { return i.name }
+func (i *treeInfo) Size() int64        { return i.size }
+func (i *treeInfo) Mode() os.FileMode  { return 0644 }
+func (i *treeInfo) ModTime() time.Time { return time.Time{} }
+func (i *treeInfo) IsDir() bool        { return i.dir }
+func (i *treeInfo) Sys() any           { return nil }
+func (i *treeInfo) ETag() string       { return i.etag }
+
+// treeSource - источник, etag директории которого меняется
+// при изменении любого вложенного файла
+type treeSource struct {
+	files.Webdav
+
+	items   map[string]*treeInfo
+	version int
+	noTree  bool
+	fail    map[string]error
+
+	mx    sync.Mutex
+	reads int
+	trees int
+}
+
+func newTreeSource() *treeSource {
+	return &treeSource{items: map[string]*treeInfo{}}
+}
+
+func (s *treeSource) put(name string, size int64) {
+	s.version++
+	s.items[name] = &treeInfo{name: path.Base(name), size: size}
+	s.touch(path.Dir(name))
+}
+
+func (s *treeSource) remove(name string) {
+	s.version++
+	for key := range s.items {
+		if key == name || len(key) > len(name) && key[:len(name)+1] == name+"/" {
+			delete(s.items, key)
+		}
+	}
+
+	s.touch(path.Dir(name))
+}
+
+func (s *treeSource) touch(dir string) {
+	for ; dir != "/"; dir = path.Dir(dir) {
+		s.items[dir] = &treeInfo{name: path.Base(dir), dir: true, etag: strconv.Itoa(s.version)}
+	}
+}
+
+func (s *treeSource) list(dir string) []os.FileInfo {
+	var result []os.FileInfo
+	for key, item := range s.items {
+		if path.Dir(key) == dir {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+func (s *treeSource) ReadDir(dir string) ([]os.FileInfo, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.reads++
+	if err := s.fail[path.Clean(dir)]; err != nil {
+		return nil, err
+	}
+
+	return s.list(path.Clean(dir)), nil
+}
+
+func (s *treeSource) ReadTree(dir string) (map[string][]os.FileInfo, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.trees++
+	if s.noTree {
+		return nil, errors.New("403 Forbidden")
+	}
+
+	dir = path.Clean(dir)
+	result := map[string][]os.FileInfo{dir: s.list(dir)}
+	for key, item := range s.items {
+		if item.dir && len(key) > len(dir) && key[:len(dir)+1] == dir+"/" {
+			result[key] = s.list(key)
+		}
+	}
+
+	return result, nil
+}
+
+func TestScanPartial(t *testing.T) {
+	src := newTreeSource()
+	src.put("/input/a.bin", 1)
+	src.put("/input/bad/b.bin", 2)
+	src.put("/input/good/c.bin", 3)
+	src.put("/input/good/deep/d.bin", 4)
+	src.fail = map[string]error{"/input/bad": errors.New("403 Forbidden")}
+
+	conf := testConfig(t)
+	conf.ScanThreads = 4
+	conf.MaxDepth = 1
+
+	list, err := files.New(src).Scan(context.Background(), conf, conf.InputPath)
+
+	var partial *engine.ScanError
+	if !errors.As(err, &partial) || len(partial.Dirs) != 1 || partial.Dirs["/input/bad"] == nil {
+		t.Fatalf("Scan() error = %v, want failure of /input/bad only", err)
+	}
+
+	var names []string
+	for _, file := range list {
+		names = append(names, file.Source)
+	}
+
+	// Файлы глубже MaxDepth не сканируются
+	if !slices.Equal(names, []string{"/input/a.bin", "/input/good/c.bin"}) {
+		t.Errorf("Scan() = %v", names)
+	}
+
+	src.fail["/input"] = errors.New("503 Service Unavailable")
+	if _, err := files.New(src).Scan(context.Background(), conf, conf.InputPath); err == nil || errors.As(err, &partial) {
+		t.Errorf("Scan() error = %v, want failure of input directory", err)
+	}
+}
+
+func scanNames(t *testing.T, f *files.Files, conf engine.Config) []string {
+	t.Helper()
+
+	list, err := f.Scan(context.Background(), conf, conf.InputPath)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	var names []string
+	for _, file := range list {
+		names = append(names, path.Clean(file.Source))
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+func TestScanIncremental(t *testing.T) {
+	src := newTreeSource()
+	src.put("/input/a.bin", 1)
+	src.put("/input/sub/b.bin", 2)
+	src.put("/input/sub/deep/c.bin", 3)
+	src.put("/input/other/d.bin", 4)
+
+	q, err := queue.New(t.TempDir() + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	defer q.Close()
+
+	conf := testConfig(t)
+	conf.ScanCache = q
+	f := files.New(src)
+
+	check := func(step string, expected []string, reads, trees int) {
+		t.Helper()
+
+		if names := scanNames(t, f, conf); !slices.Equal(names, expected) {
+			t.Errorf("%s: Scan() = %v, want %v", step, names, expected)
+		}
+
+		if src.reads != reads || src.trees != trees {
+			t.Errorf("%s: %d directory reads and %d tree reads, want %d and %d", step, src.reads, src.trees, reads, trees)
+		}
+	}
+
+	all := []string{"/input/a.bin", "/input/other/d.bin", "/input/sub/b.bin", "/input/sub/deep/c.bin"}
+
+	// Первое сканирование читает все дерево одним запросом
+	check("first scan", all, 0, 1)
+
+	// Неизменные вложенные директории берутся из кэша
+	check("unchanged", all, 1, 1)
+
+	src.put("/input/sub/deep/e.bin", 5)
+	check("changed", append(slices.Clone(all), "/input/sub/deep/e.bin"), 4, 1)
+
+	src.remove("/input/other")
+	check("removed", []string{"/input/a.bin", "/input/sub/b.bin", "/input/sub/deep/c.bin", "/input/sub/deep/e.bin"}, 5, 1)
+
+	if state, err := q.ScannedDir("/input/other"); err != nil || state != nil {
+		t.Errorf("state of removed directory = %v, %v, want nil", state, err)
+	}
+
+	// Каждое второе сканирование читает все директории
+	conf.FullScanEvery = 2
+	check("incremental", []string{"/input/a.bin", "/input/sub/b.bin", "/input/sub/deep/c.bin", "/input/sub/deep/e.bin"}, 6, 1)
+	check("full", []string{"/input/a.bin", "/input/sub/b.bin", "/input/sub/deep/c.bin", "/input/sub/deep/e.bin"}, 6, 2)
+}
+
+func TestScanTreeUnsupported(t *testing.T) {
+	src := newTreeSource()
+	src.noTree = true
+	src.put("/input/a.bin", 1)
+	src.put("/input/sub/b.bin", 2)
+
+	q, err := queue.New(t.TempDir() + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	defer q.Close()
+
+	conf := testConfig(t)
+	conf.ScanCache = q
+	f := files.New(src)
+
+	for range 2 {
+		if names := scanNames(t, f, conf); len(names) != 2 {
+			t.Errorf("Scan() = %v", names)
+		}
+	}
+
+	// Отказ сервера запоминается, повторно поддерево не запрашивается
+	if src.trees != 1 || src.reads != 3 {
+		t.Errorf("%d tree reads and %d directory reads, want 1 and 3", src.trees, src.reads)
+	}
+}
+
+func TestScanCanceled(t *testing.T) {
+	src := newTreeSource()
+	src.put("/input/a.bin", 1)
+	src.put("/input/sub/b.bin", 2)
+
+	q, err := queue.New(t.TempDir() + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	defer q.Close()
+
+	conf := testConfig(t)
+	conf.ScanCache = q
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := files.New(src).Scan(ctx, conf, conf.InputPath); !errors.Is(err, context.Canceled) {
+		t.Errorf("Scan() error = %v, want context.Canceled", err)
+	}
+
+	// Прерванное сканирование не сохраняется в кэш
+	if state, err := q.ScannedDir("/input"); err != nil || state != nil {
+		t.Errorf("state of interrupted scan = %v, %v, want nil", state, err)
+	}
+}
@@ -0,0 +1,240 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Префикс шаблона, который следует трактовать как регулярное выражение
+const regexPrefix = "re:"
+
+type Options struct {
+	// Шаблоны файлов, которые следует загружать
+	//
+	// Если список пуст, загружаются все файлы
+	Include []string
+
+	// Шаблоны файлов и директорий, которые следует пропускать
+	Exclude []string
+
+	// Шаблоны директорий, в которые не следует спускаться
+	ExcludeDirs []string
+
+	// Минимальный размер файла в байтах (0 - без ограничения)
+	MinSize int64
+
+	// Максимальный размер файла в байтах (0 - без ограничения)
+	MaxSize int64
+}
+
+// New - создает фильтр из настроек
+//
+// Шаблоны записываются в стиле gitignore:
+//   - шаблон без "/" сравнивается с именем на любой глубине (*.tmp)
+//   - шаблон с "/" сравнивается с путем от корня (docs/*.pdf, /cache)
+//   - "**" соответствует любому количеству директорий (photos/**/raw)
+//   - завершающий "/" ограничивает шаблон директориями (tmp/)
+//
+// Шаблон с префиксом "re:" является регулярным выражением
+// и сравнивается с путем относительно корня сканирования
+func New(opts Options) (*Filter, error) {
+	f := &Filter{
+		minSize: opts.MinSize,
+		maxSize: opts.MaxSize,
+	}
+
+	var err error
+
+	f.include, err = compileAll(opts.Include)
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+
+	f.exclude, err = compileAll(opts.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+
+	f.excludeDirs, err = compileAll(opts.ExcludeDirs)
+	if err != nil {
+		return nil, fmt.Errorf("exclude dirs: %w", err)
+	}
+
+	return f, nil
+}
+
+type Filter struct {
+	include     []pattern
+	exclude     []pattern
+	excludeDirs []pattern
+	minSize     int64
+	maxSize     int64
+}
+
+// SkipDir - проверяет, нужно ли пропустить директорию
+// path - путь директории относительно корня сканирования
+func (f *Filter) SkipDir(path string) bool {
+	path = clean(path)
+
+	for _, p := range f.excludeDirs {
+		if p.match(path, true) {
+			return true
+		}
+	}
+
+	for _, p := range f.exclude {
+		if p.match(path, true) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Skip - проверяет, нужно ли пропустить файл
+// path - путь файла относительно корня сканирования
+func (f *Filter) Skip(path string, size int64) bool {
+	path = clean(path)
+
+	if f.minSize > 0 && size < f.minSize {
+		return true
+	}
+
+	if f.maxSize > 0 && size > f.maxSize {
+		return true
+	}
+
+	for _, p := range f.exclude {
+		if p.match(path, false) {
+			return true
+		}
+	}
+
+	if len(f.include) == 0 {
+		return false
+	}
+
+	for _, p := range f.include {
+		if p.match(path, false) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type pattern struct {
+	re       *regexp.Regexp
+	basename bool // Сравнивать только с именем файла
+	dirOnly  bool // Шаблон применяется только к директориям
+}
+
+func (p pattern) match(value string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.basename {
+		value = path.Base(value)
+	}
+
+	return p.re.MatchString(value)
+}
+
+func compileAll(items []string) ([]pattern, error) {
+	var result []pattern
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		p, err := compile(item)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", item, err)
+		}
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+func compile(item string) (pattern, error) {
+	if expr, ok := strings.CutPrefix(item, regexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		return pattern{re: re}, err
+	}
+
+	var p pattern
+
+	if trimmed, ok := strings.CutSuffix(item, "/"); ok {
+		p.dirOnly = true
+		item = trimmed
+	}
+
+	if !strings.Contains(item, "/") {
+		p.basename = true
+	}
+
+	item = strings.TrimPrefix(item, "/")
+
+	re, err := regexp.Compile("^" + globToRegex(item) + "$")
+	p.re = re
+	return p, err
+}
+
+// globToRegex - преобразует glob шаблон в регулярное выражение
+func globToRegex(glob string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+
+				// "**/" соответствует нулю и более директорий
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+
+				continue
+			}
+
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			sb.WriteString("[" + class + "]")
+			i += end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return sb.String()
+}
+
+func clean(value string) string {
+	return strings.TrimPrefix(path.Clean("/"+value), "/")
+}
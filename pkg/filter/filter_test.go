@@ -0,0 +1,89 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/ReanSn0w/wddl/pkg/filter"
+)
+
+func TestSkip(t *testing.T) {
+	f, err := filter.New(filter.Options{
+		Include: []string{"*.mkv", "docs/**/*.pdf", "re:^music/.*\\.flac$"},
+		Exclude: []string{"*.tmp", ".DS_Store", "/docs/private/*"},
+		MinSize: 10,
+		MaxSize: 1000,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		size int64
+		want bool
+	}{
+		{name: "Included by basename", path: "movies/a.mkv", size: 100, want: false},
+		{name: "Included with leading slash", path: "/a.mkv", size: 100, want: false},
+		{name: "Not included", path: "movies/a.avi", size: 100, want: true},
+		{name: "Included by double star", path: "docs/a/b/c.pdf", size: 100, want: false},
+		{name: "Double star matches zero dirs", path: "docs/c.pdf", size: 100, want: false},
+		{name: "Included by regex", path: "music/x/y.flac", size: 100, want: false},
+		{name: "Excluded by basename", path: "a/b.tmp", size: 100, want: true},
+		{name: "Excluded exact name", path: "x/.DS_Store", size: 100, want: true},
+		{name: "Excluded anchored", path: "docs/private/a.pdf", size: 100, want: true},
+		{name: "Too small", path: "a.mkv", size: 5, want: true},
+		{name: "Too large", path: "a.mkv", size: 5000, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Skip(tt.path, tt.size); got != tt.want {
+				t.Errorf("Skip(%q, %d) = %v, want %v", tt.path, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipDir(t *testing.T) {
+	f, err := filter.New(filter.Options{
+		Exclude:     []string{"cache/", "*.tmp"},
+		ExcludeDirs: []string{"/backup", "**/node_modules"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "cache", want: true},
+		{path: "a/cache", want: true},
+		{path: "upload.tmp", want: true},
+		{path: "backup", want: true},
+		{path: "a/backup", want: false},
+		{path: "a/b/node_modules", want: true},
+		{path: "photos", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := f.SkipDir(tt.path); got != tt.want {
+				t.Errorf("SkipDir(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	// Шаблон директории не применяется к файлам
+	if f.Skip("cache", 100) {
+		t.Error("Skip() should not apply directory-only pattern to files")
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	_, err := filter.New(filter.Options{Exclude: []string{"re:("}})
+	if err == nil {
+		t.Error("New() should fail on invalid regex")
+	}
+}
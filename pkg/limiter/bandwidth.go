@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// NewBandwidth - создает общий для всех загрузок ограничитель скорости
+//
+// schedule определяет общую скорость, perFile - скорость одного файла
+func NewBandwidth(log lgr.L, schedule *Schedule, perFile int64) *Bandwidth {
+	b := &Bandwidth{
+		log:      log,
+		schedule: schedule,
+		global:   New(schedule.RateAt(time.Now())),
+	}
+
+	b.perFile.Store(perFile)
+	b.override.Store(-1)
+
+	return b
+}
+
+// Bandwidth - общий и пофайловый лимиты скорости загрузки
+type Bandwidth struct {
+	log      lgr.L
+	schedule *Schedule
+	global   *Limiter
+	perFile  atomic.Int64
+	override atomic.Int64 // -1 - действует расписание
+}
+
+// Run - применяет расписание до завершения контекста
+func (b *Bandwidth) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.apply(time.Now())
+		}
+	}
+}
+
+// Set - устанавливает общую скорость вручную, расписание
+// не действует до вызова Reset
+func (b *Bandwidth) Set(rate int64) {
+	b.override.Store(max(rate, 0))
+	b.apply(time.Now())
+}
+
+// Reset - возвращает общую скорость к расписанию
+func (b *Bandwidth) Reset() {
+	b.override.Store(-1)
+	b.apply(time.Now())
+}
+
+// SetPerFile - изменяет скорость одного файла, в том числе для начатых загрузок
+func (b *Bandwidth) SetPerFile(rate int64) {
+	b.perFile.Store(max(rate, 0))
+}
+
+// Rates - возвращает текущие общую скорость и скорость одного файла
+func (b *Bandwidth) Rates() (global int64, perFile int64) {
+	return b.global.Rate(), b.perFile.Load()
+}
+
+// Limit - возвращает обертку для потоков одного файла,
+// потоки файла делят между собой пофайловый лимит
+//
+// Завершение ctx прерывает ожидание лимита в потоках файла
+func (b *Bandwidth) Limit(ctx context.Context) func(io.Reader) io.Reader {
+	file := New(b.perFile.Load())
+
+	return func(r io.Reader) io.Reader {
+		return Reader(ctx, &perFileReader{Reader: r, rate: &b.perFile, file: file}, b.global, file)
+	}
+}
+
+// perFileReader - поток, перед каждым чтением применяющий
+// к ограничителю файла текущую пофайловую скорость
+type perFileReader struct {
+	io.Reader
+	rate *atomic.Int64
+	file *Limiter
+}
+
+func (r *perFileReader) Read(p []byte) (int, error) {
+	if rate := r.rate.Load(); rate != r.file.Rate() {
+		r.file.SetRate(rate)
+	}
+
+	return r.Reader.Read(p)
+}
+
+func (b *Bandwidth) apply(now time.Time) {
+	rate := b.override.Load()
+	if rate < 0 {
+		rate = b.schedule.RateAt(now)
+	}
+
+	if b.global.Rate() != rate {
+		b.log.Logf("[INFO] download rate limit changed to %d B/s", rate)
+		b.global.SetRate(rate)
+	}
+}
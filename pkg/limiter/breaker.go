@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NewBreaker - создает общую паузу запросов к удаленному хранилищу
+func NewBreaker() *Breaker {
+	return &Breaker{}
+}
+
+// Breaker - пауза, разделяемая воркерами нескольких заданий
+//
+// Когда сервер ограничивает частоту запросов, отказ получивший
+// воркер приостанавливает остальных, чтобы они не продлевали
+// ограничение новыми запросами
+type Breaker struct {
+	mx    sync.Mutex
+	until time.Time
+}
+
+// Trip - приостанавливает запросы на время d,
+// более поздняя уже назначенная пауза сохраняется
+func (b *Breaker) Trip(d time.Duration) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if until := time.Now().Add(d); until.After(b.until) {
+		b.until = until
+	}
+}
+
+// Until - возвращает время окончания паузы
+func (b *Breaker) Until() time.Time {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.until
+}
+
+// Wait - ожидает окончания паузы или завершения контекста
+//
+// Пауза может быть продлена во время ожидания
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		wait := time.Until(b.Until())
+		if wait <= 0 {
+			return ctx.Err()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package limiter
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Максимальный размер порции данных, на которую запрашиваются токены
+const chunkSize = 32 << 10 // 32 KB
+
+// New - создает ограничитель скорости в байтах в секунду
+// rate <= 0 означает отсутствие ограничения
+func New(rate int64) *Limiter {
+	return &Limiter{
+		rate: rate,
+		last: time.Now(),
+	}
+}
+
+// Limiter - ограничитель скорости по алгоритму token bucket
+//
+// Скорость может быть изменена в любой момент, ожидающие
+// потоки подхватывают новое значение без перезапуска
+type Limiter struct {
+	mx     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// SetRate - изменяет скорость ограничителя
+func (l *Limiter) SetRate(rate int64) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.refill(time.Now())
+	l.rate = rate
+}
+
+// Rate - возвращает текущую скорость ограничителя
+func (l *Limiter) Rate() int64 {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	return l.rate
+}
+
+// Wait - ожидает возможности передать n байт или завершения контекста
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	for {
+		l.mx.Lock()
+
+		if l.rate <= 0 {
+			l.mx.Unlock()
+			return nil
+		}
+
+		now := time.Now()
+		l.refill(now)
+
+		if l.tokens >= float64(n) {
+			l.tokens -= float64(n)
+			l.mx.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((float64(n) - l.tokens) / float64(l.rate) * float64(time.Second))
+		l.mx.Unlock()
+
+		// Ожидание ограничено, чтобы быстрее реагировать на смену скорости
+		timer := time.NewTimer(min(wait, time.Millisecond*200))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	if l.rate <= 0 {
+		l.tokens = 0
+		return
+	}
+
+	// Запас не превышает секунды трафика, но вмещает хотя бы одну порцию
+	limit := float64(max(l.rate, chunkSize))
+	l.tokens = min(l.tokens+elapsed*float64(l.rate), limit)
+}
+
+// Reader - оборачивает поток ограничителями скорости
+//
+// Завершение ctx прерывает ожидание, чтение возвращает ошибку контекста
+func Reader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	return &reader{ctx: ctx, r: r, limiters: limiters}
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		for _, l := range r.limiters {
+			if l == nil {
+				continue
+			}
+
+			if werr := l.Wait(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,204 @@
+package limiter_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/limiter"
+	"github.com/go-pkgz/lgr"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "unlimited", want: 0},
+		{value: "1024", want: 1024},
+		{value: "512K", want: 512 << 10},
+		{value: "2M", want: 2 << 20},
+		{value: "2MB", want: 2 << 20},
+		{value: "1.5m", want: 3 << 19},
+		{value: "1G", want: 1 << 30},
+		{value: "fast", wantErr: true},
+		{value: "-1M", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := limiter.ParseRate(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s, err := limiter.ParseSchedule("10M", []string{"09:00-18:00=2M", "22:00-06:00=0"})
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		at   time.Time
+		want int64
+	}{
+		{at: day(8, 59), want: 10 << 20},
+		{at: day(9, 0), want: 2 << 20},
+		{at: day(17, 59), want: 2 << 20},
+		{at: day(18, 0), want: 10 << 20},
+		{at: day(23, 0), want: 0},
+		{at: day(3, 0), want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := s.RateAt(tt.at); got != tt.want {
+			t.Errorf("RateAt(%s) = %d, want %d", tt.at.Format("15:04"), got, tt.want)
+		}
+	}
+
+	if _, err := limiter.ParseSchedule("0", []string{"9-18=2M"}); err == nil {
+		t.Error("ParseSchedule() should fail on invalid rule")
+	}
+}
+
+func TestReader(t *testing.T) {
+	const rate = 256 << 10
+
+	data := bytes.Repeat([]byte("x"), rate/2)
+	l := limiter.New(rate)
+
+	started := time.Now()
+	n, err := io.Copy(io.Discard, limiter.Reader(context.Background(), bytes.NewReader(data), l))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Copy() = %d, %v", n, err)
+	}
+
+	// Половина секундного объема без накопленного запаса
+	if elapsed := time.Since(started); elapsed < time.Millisecond*400 {
+		t.Errorf("limited read took %v, want at least 400ms", elapsed)
+	}
+
+	// Новая скорость применяется к уже созданному ограничителю
+	l.SetRate(0)
+	started = time.Now()
+	_, _ = io.Copy(io.Discard, limiter.Reader(context.Background(), bytes.NewReader(data), l))
+	if elapsed := time.Since(started); elapsed > time.Millisecond*100 {
+		t.Errorf("unlimited read took %v", elapsed)
+	}
+}
+
+func TestReaderCancel(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 256<<10)
+	l := limiter.New(1 << 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	// При низкой скорости ожидание прерывается отменой, а не поступлением токенов
+	started := time.Now()
+	_, err := io.Copy(io.Discard, limiter.Reader(ctx, bytes.NewReader(data), l))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Copy() error = %v, want context deadline", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("canceled read took %v", elapsed)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	slots := limiter.NewSlots(1)
+
+	if err := slots.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if err := slots.Acquire(ctx); err == nil {
+		t.Fatalf("Acquire() should wait while the only slot is taken")
+	}
+
+	slots.Release()
+
+	if err := slots.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() after Release() error = %v", err)
+	}
+
+	unlimited := limiter.NewSlots(0)
+	for range 10 {
+		if err := unlimited.Acquire(ctx); err != nil {
+			t.Fatalf("unlimited Acquire() error = %v", err)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := limiter.NewBreaker()
+
+	started := time.Now()
+	if err := b.Wait(context.Background()); err != nil || time.Since(started) > 10*time.Millisecond {
+		t.Fatalf("Wait() without pause = %v after %v", err, time.Since(started))
+	}
+
+	b.Trip(100 * time.Millisecond)
+
+	// Более короткая пауза не сокращает назначенную
+	b.Trip(time.Millisecond)
+
+	if err := b.Wait(context.Background()); err != nil || time.Since(started) < 100*time.Millisecond {
+		t.Errorf("Wait() = %v after %v, want pause of 100ms", err, time.Since(started))
+	}
+
+	b.Trip(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want context deadline", err)
+	}
+}
+
+func TestBandwidthPerFile(t *testing.T) {
+	const rate = 256 << 10
+
+	schedule, err := limiter.ParseSchedule("0", nil)
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+
+	b := limiter.NewBandwidth(lgr.NoOp, schedule, rate)
+	limit := b.Limit(context.Background())
+
+	data := bytes.Repeat([]byte("x"), rate/2)
+	started := time.Now()
+	_, _ = io.Copy(io.Discard, limit(bytes.NewReader(data)))
+	if elapsed := time.Since(started); elapsed < time.Millisecond*400 {
+		t.Errorf("limited read took %v, want at least 400ms", elapsed)
+	}
+
+	// Новая скорость файла применяется к начатой загрузке
+	b.SetPerFile(0)
+	started = time.Now()
+	_, _ = io.Copy(io.Discard, limit(bytes.NewReader(data)))
+	if elapsed := time.Since(started); elapsed > time.Millisecond*100 {
+		t.Errorf("unlimited read took %v", elapsed)
+	}
+}
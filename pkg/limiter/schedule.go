@@ -0,0 +1,155 @@
+package limiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRate - разбирает скорость вида "512K", "2M", "1G", "2MB"
+//
+// Пустая строка, "0" и "unlimited" означают отсутствие ограничения
+func ParseRate(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "/S")
+	value = strings.TrimSuffix(value, "B")
+
+	if value == "" || value == "0" || value == "UNLIMITED" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch value[len(value)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	}
+
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// Rule - скорость, действующая в промежутке времени суток
+type Rule struct {
+	From time.Duration
+	To   time.Duration
+	Rate int64
+}
+
+// contains - проверяет попадание времени суток в промежуток,
+// промежуток может переходить через полночь (22:00-06:00)
+func (r Rule) contains(at time.Duration) bool {
+	if r.From <= r.To {
+		return at >= r.From && at < r.To
+	}
+
+	return at >= r.From || at < r.To
+}
+
+// ParseSchedule - разбирает расписание скорости
+//
+// Правила записываются в виде "09:00-18:00=2M", вне правил
+// действует скорость по умолчанию, при пересечении правил
+// выигрывает первое
+func ParseSchedule(defaultRate string, rules []string) (*Schedule, error) {
+	rate, err := ParseRate(defaultRate)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schedule{Default: rate}
+
+	for _, item := range rules {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		rule, err := parseRule(item)
+		if err != nil {
+			return nil, fmt.Errorf("schedule rule %q: %w", item, err)
+		}
+
+		s.Rules = append(s.Rules, rule)
+	}
+
+	return s, nil
+}
+
+// Schedule - расписание скорости загрузки
+type Schedule struct {
+	Default int64
+	Rules   []Rule
+}
+
+// RateAt - возвращает скорость, действующую в указанный момент
+func (s *Schedule) RateAt(t time.Time) int64 {
+	at := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	for _, rule := range s.Rules {
+		if rule.contains(at) {
+			return rule.Rate
+		}
+	}
+
+	return s.Default
+}
+
+func parseRule(item string) (Rule, error) {
+	period, rate, ok := strings.Cut(item, "=")
+	if !ok {
+		return Rule{}, fmt.Errorf("expected FROM-TO=RATE")
+	}
+
+	from, to, ok := strings.Cut(period, "-")
+	if !ok {
+		return Rule{}, fmt.Errorf("expected FROM-TO=RATE")
+	}
+
+	var (
+		rule Rule
+		err  error
+	)
+
+	rule.From, err = parseClock(from)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	rule.To, err = parseClock(to)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	rule.Rate, err = ParseRate(rate)
+	return rule, err
+}
+
+// parseClock - разбирает время суток вида "HH:MM"
+func parseClock(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,42 @@
+package limiter
+
+import "context"
+
+// NewSlots - создает общий лимит одновременных загрузок
+// n <= 0 означает отсутствие ограничения
+func NewSlots(n int) *Slots {
+	if n <= 0 {
+		return &Slots{}
+	}
+
+	return &Slots{ch: make(chan struct{}, n)}
+}
+
+// Slots - лимит одновременных загрузок, разделяемый
+// воркерами нескольких заданий
+type Slots struct {
+	ch chan struct{}
+}
+
+// Acquire - ожидает свободное место или завершение контекста
+func (s *Slots) Acquire(ctx context.Context) error {
+	if s.ch == nil {
+		return nil
+	}
+
+	select {
+	case s.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release - освобождает место, занятое Acquire
+func (s *Slots) Release() {
+	if s.ch == nil {
+		return
+	}
+
+	<-s.ch
+}
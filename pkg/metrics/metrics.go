@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	DownloadedBytes = NewCounter("wddl_downloaded_bytes_total", "Bytes downloaded from remote storage")
+	UploadedBytes   = NewCounter("wddl_uploaded_bytes_total", "Bytes uploaded to remote storage")
+	FilesCompleted  = NewCounter("wddl_files_completed_total", "Files downloaded successfully")
+	FilesFailed     = NewCounter("wddl_files_failed_total", "Download cycles finished with error")
+	DownloadRetries = NewCounter("wddl_download_retries_total", "Download attempts retried after error")
+	Throttled       = NewCounter("wddl_throttled_total", "Requests rejected by remote storage with 429 or 503")
+	RemoteDeletions = NewCounter("wddl_remote_deletions_total", "Remote files deleted after download")
+	LocalDeletions  = NewCounter("wddl_local_deletions_total", "Local copies deleted or moved to trash after remote deletion")
+	Scans           = NewCounter("wddl_scans_total", "Remote storage scans performed")
+	ScanErrors      = NewCounter("wddl_scan_errors_total", "Remote storage scans finished with error")
+	ScanDirsRead    = NewCounter("wddl_scan_dirs_read_total", "Remote directories listed during scans")
+	ScanDirsCached  = NewCounter("wddl_scan_dirs_cached_total", "Unchanged remote directories taken from scan cache")
+
+	QueueFiles    = NewGauge("wddl_queue_files", "Files waiting in queue")
+	QueueBytes    = NewGauge("wddl_queue_bytes", "Total size of files waiting in queue")
+	QueueFailed   = NewGauge("wddl_queue_failed_files", "Files in queue whose last download cycle failed")
+	QueueDead     = NewGauge("wddl_queue_dead_files", "Files moved to dead list")
+	ScanDuration  = NewGauge("wddl_scan_duration_seconds", "Duration of the last remote storage scan")
+	ScanFound     = NewGauge("wddl_scan_found_files", "Files found by the last remote storage scan")
+	ActiveWorkers = NewGauge("wddl_active_workers", "Download workers currently busy")
+	WorkersLimit  = NewGauge("wddl_workers_limit", "Maximum number of download workers")
+	Paused        = NewGauge("wddl_paused", "Whether dispatching of new downloads is paused")
+	InWindow      = NewGauge("wddl_in_window", "Whether current time is inside the download window")
+)
+
+var (
+	mx      sync.Mutex
+	metrics []metric
+)
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+func register(m metric) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	metrics = append(metrics, m)
+}
+
+// Write - записывает значения всех метрик в текстовом формате Prometheus
+func Write(w io.Writer) error {
+	mx.Lock()
+	defer mx.Unlock()
+
+	for _, m := range metrics {
+		err := m.write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler - возвращает обработчик /metrics
+//
+// Функция collect вызывается перед каждой выдачей метрик
+// и позволяет обновить значения, которые считаются по запросу
+func Handler(collect func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if collect != nil {
+			collect()
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = Write(w)
+	})
+}
+
+// Counter - монотонно возрастающий счетчик
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		c.name, c.help, c.name, c.name, c.value.Load())
+	return err
+}
+
+// Gauge - значение, которое может как расти, так и уменьшаться
+type Gauge struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		value := math.Float64frombits(old) + delta
+		if g.bits.CompareAndSwap(old, math.Float64bits(value)) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n",
+		g.name, g.help, g.name, g.name, g.Value())
+	return err
+}
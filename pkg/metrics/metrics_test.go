@@ -0,0 +1,60 @@
+package metrics_test
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestHandler(t *testing.T) {
+	// Метрики глобальны, значения приводятся к эталонным
+	// и при повторных запусках теста (-count)
+	metrics.DownloadedBytes.Add(1536 - metrics.DownloadedBytes.Value())
+	metrics.FilesCompleted.Add(1 - metrics.FilesCompleted.Value())
+	metrics.FilesCompleted.Inc()
+	metrics.ActiveWorkers.Set(0)
+	metrics.ActiveWorkers.Add(3)
+	metrics.ActiveWorkers.Add(-1)
+	metrics.ScanDuration.Set(0.25)
+
+	collected := false
+	handler := metrics.Handler(func() {
+		collected = true
+		metrics.QueueBytes.Set(3 << 30)
+		metrics.Paused.Set(1)
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !collected {
+		t.Errorf("collect was not called before writing metrics")
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want prometheus text format", ct)
+	}
+
+	golden := "testdata/metrics.golden"
+	if *update {
+		if err := os.WriteFile(golden, rec.Body.Bytes(), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	if got := rec.Body.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("metrics output mismatch, run go test -update to accept\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
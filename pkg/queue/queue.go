@@ -4,87 +4,291 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ReanSn0w/wddl/pkg/engine"
 	"github.com/boltdb/bolt"
-	"github.com/go-pkgz/lgr"
 )
 
-var (
-	queueBucket = []byte("queue")
-)
+// Ошибка открытия базы данных, которую использует другой процесс
+var ErrLocked = errors.New("database is locked by another process")
+
+// buckets - имена корзин базы данных одного задания
+type buckets struct {
+	queue   []byte
+	dead    []byte
+	done    []byte
+	history []byte
+	scan    []byte
+}
+
+// bucketsOf - возвращает имена корзин задания
+//
+// Очередь без имени задания использует корзины верхнего уровня,
+// поэтому базы данных, созданные до появления заданий, остаются рабочими
+func bucketsOf(job string) buckets {
+	prefix := ""
+	if job != "" {
+		prefix = "job/" + job + "/"
+	}
+
+	return buckets{
+		queue:   []byte(prefix + "queue"),
+		dead:    []byte(prefix + "dead"),
+		done:    []byte(prefix + "done"),
+		history: []byte(prefix + "history"),
+		scan:    []byte(prefix + "scan"),
+	}
+}
 
+// New - открывает базу данных и возвращает очередь без имени задания
+//
+// Закрытие очереди закрывает базу данных
 func New(path string) (*Queue, error) {
-	db, err := bolt.Open(path, 0600, nil)
+	return NewJob(path, "")
+}
+
+// NewJob - открывает базу данных и возвращает очередь задания job
+//
+// Закрытие очереди закрывает базу данных
+func NewJob(path string, job string) (*Queue, error) {
+	db, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) (err error) {
-		_, err = tx.CreateBucketIfNotExists(queueBucket)
+	q, err := db.Queue(job)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	q.closer = db
+	return q, nil
+}
+
+// NewReadOnly - открывает базу данных только для чтения
+// и возвращает очередь задания job
+//
+// Закрытие очереди закрывает базу данных
+func NewReadOnly(path string, job string) (*Queue, error) {
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := db.Queue(job)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	q.closer = db
+	return q, nil
+}
+
+// Open - открывает базу данных очередей нескольких заданий
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err == bolt.ErrTimeout {
+		return nil, ErrLocked
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{db: db}, nil
+}
+
+// OpenReadOnly - открывает базу данных только для чтения
+//
+// Очереди такой базы не создают корзины и не возвращают прерванные
+// загрузки в ожидание, поэтому пробный запуск не изменяет базу.
+// Отсутствующая база данных открывается как пустая
+func OpenReadOnly(path string) (*DB, error) {
+	var temp string
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		temp, err = emptyDB()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		return nil
-	})
+		path = temp
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5, ReadOnly: true})
+	if err == bolt.ErrTimeout {
+		err = ErrLocked
+	}
 
 	if err != nil {
+		if temp != "" {
+			os.Remove(temp)
+		}
+
 		return nil, err
 	}
 
-	q := &Queue{
-		db: db,
+	return &DB{db: db, readOnly: true, temp: temp}, nil
+}
+
+// emptyDB - создает пустую базу данных во временном файле
+func emptyDB() (string, error) {
+	f, err := os.CreateTemp("", "wddl-*.db")
+	if err != nil {
+		return "", err
 	}
 
-	return q, nil
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), db.Close()
 }
 
-type Queue struct {
-	db *bolt.DB
+// DB - база данных, в которой каждое задание хранит
+// очередь, мертвые задачи и журнал в отдельных корзинах
+type DB struct {
+	db       *bolt.DB
+	readOnly bool
+	temp     string // Временный файл пустой базы, открытой только для чтения
 }
 
-// Add - добавляет файл в очередь
-func (q *Queue) Add(file engine.File) error {
-	return q.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(queueBucket)
+// Close - закрывает базу данных
+func (d *DB) Close() error {
+	err := d.db.Close()
+
+	if d.temp != "" {
+		os.Remove(d.temp)
+	}
+
+	return err
+}
+
+// Queue - возвращает очередь задания job
+//
+// Закрытие очереди не закрывает базу данных
+func (d *DB) Queue(job string) (*Queue, error) {
+	names := bucketsOf(job)
+
+	q := &Queue{
+		db:      d.db,
+		buckets: names,
+		wake:    make(chan struct{}),
+	}
+
+	// Отсутствующие корзины база только для чтения считает пустыми
+	if d.readOnly {
+		return q, nil
+	}
+
+	err := d.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket, err := tx.CreateBucketIfNotExists(names.queue)
 		if err != nil {
 			return err
 		}
 
-		key := []byte(file.ID)
+		_, err = tx.CreateBucketIfNotExists(names.dead)
+		if err != nil {
+			return err
+		}
 
-		buf := new(bytes.Buffer)
-		err = json.NewEncoder(buf).Encode(file)
+		_, err = tx.CreateBucketIfNotExists(names.done)
 		if err != nil {
 			return err
 		}
 
-		err = bucket.Put(key, buf.Bytes())
+		_, err = tx.CreateBucketIfNotExists(names.history)
 		if err != nil {
 			return err
 		}
 
+		_, err = tx.CreateBucketIfNotExists(names.scan)
+		if err != nil {
+			return err
+		}
+
+		// Загрузки, прерванные остановкой процесса,
+		// возвращаются в ожидание
+		return recoverActive(bucket)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+type Queue struct {
+	db      *bolt.DB
+	closer  *DB // База данных, открытая очередью
+	buckets buckets
+
+	mx   sync.Mutex
+	wake chan struct{} // Закрывается при появлении файлов для выдачи
+}
+
+// Close - закрывает базу данных очереди
+func (q *Queue) Close() error {
+	if q.closer == nil {
 		return nil
+	}
+
+	return q.closer.Close()
+}
+
+// Add - добавляет файл в очередь
+func (q *Queue) Add(file engine.File) error {
+	defer q.notify()
+
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(q.buckets.queue)
+		if err != nil {
+			return err
+		}
+
+		if file.Status.State == "" {
+			file.Status.State = engine.StatePending
+			file.Status.UpdatedAt = time.Now()
+		}
+
+		// Повторно добавленный файл больше не считается завершенным
+		if done := tx.Bucket(q.buckets.done); done != nil {
+			err = done.Delete([]byte(file.ID))
+			if err != nil {
+				return err
+			}
+		}
+
+		return put(bucket, file)
 	})
 }
 
-// Exists - проверяет наличие файла в очереди
+// Exists - проверяет наличие файла в очереди или в списке мертвых задач
 // в случае его отсутствия возвращает ошибку
 func (q *Queue) Exists(id string) error {
 	return q.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(queueBucket)
-		if bucket == nil {
-			return engine.ErrNotFound
-		}
-
 		key := []byte(id)
-		if bucket.Get(key) == nil {
-			return engine.ErrNotFound
+
+		for _, name := range [][]byte{q.buckets.queue, q.buckets.dead} {
+			bucket := tx.Bucket(name)
+			if bucket != nil && bucket.Get(key) != nil {
+				return nil
+			}
 		}
 
-		return nil
+		return engine.ErrNotFound
 	})
 }
 
@@ -97,7 +301,7 @@ func (q *Queue) Len() (int, error) {
 	)
 
 	err = q.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(queueBucket)
+		bucket := tx.Bucket(q.buckets.queue)
 		if bucket == nil {
 			return engine.ErrNotFound
 		}
@@ -117,7 +321,7 @@ func (q *Queue) Stat() (*engine.Stat, error) {
 	)
 
 	err = q.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(queueBucket)
+		bucket := tx.Bucket(q.buckets.queue)
 		if bucket == nil {
 			return engine.ErrNotFound
 		}
@@ -132,6 +336,25 @@ func (q *Queue) Stat() (*engine.Stat, error) {
 
 			stat.Files++
 			stat.FullSize += file.Size
+
+			switch file.Status.State {
+			case engine.StateActive:
+				stat.Active++
+			case engine.StateFailed:
+				stat.Failed++
+			}
+		}
+
+		if dead := tx.Bucket(q.buckets.dead); dead != nil {
+			stat.Dead = dead.Stats().KeyN
+		}
+
+		if done := tx.Bucket(q.buckets.done); done != nil {
+			stat.Done = done.Stats().KeyN
+		}
+
+		if history := tx.Bucket(q.buckets.history); history != nil {
+			stat.Delivered = history.Stats().KeyN
 		}
 
 		return nil
@@ -149,7 +372,7 @@ func (q *Queue) List(filter func(f engine.File) error) ([]engine.File, error) {
 	)
 
 	err = q.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(queueBucket)
+		bucket := tx.Bucket(q.buckets.queue)
 		if bucket == nil {
 			return engine.ErrNotFound
 		}
@@ -177,54 +400,650 @@ func (q *Queue) List(filter func(f engine.File) error) ([]engine.File, error) {
 	return result, err
 }
 
-// Chan - возвращает канал с файлами из очереди
-// в случае их присутствия в очереди в противном случае породит go рутину
-// которая будет периодически опрашивать очередь на наличие новых файлов
-func (q *Queue) Chan(ctx context.Context, log lgr.L, filter func(f engine.File) error) <-chan engine.File {
-	ch := make(chan engine.File)
+// Claim - выдает следующий готовый к загрузке файл одному исполнителю
+//
+// Файл переводится в состояние active с арендой на время lease и не
+// выдается повторно, пока аренда не истечет или исполнитель не сообщит
+// о результате. Если готовых файлов нет, метод ожидает их появления
+// до завершения контекста
+func (q *Queue) Claim(ctx context.Context, owner string, order engine.Order, lease time.Duration) (engine.File, error) {
+	for {
+		wake := q.waiter()
 
-	go func() {
-		defer close(ch)
+		file, next, err := q.claim(owner, order, lease)
+		if err != nil {
+			return engine.File{}, err
+		}
 
-		ticker := time.NewTicker(time.Second * 3)
+		if file != nil {
+			return *file, nil
+		}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				items, err := q.List(filter)
-				if err != nil {
-					log.Logf("[ERROR] listing files: %v", err)
-					continue
-				}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return engine.File{}, ctx.Err()
+		case <-wake:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// Renew - продлевает аренду файла исполнителем owner
+//
+// Возвращает ErrLeaseLost, если аренда истекла и файл
+// был выдан другому исполнителю или удален из очереди
+func (q *Queue) Renew(id string, owner string, lease time.Duration) error {
+	err := q.update(id, func(file *engine.File) error {
+		if !leased(file, owner) {
+			return engine.ErrLeaseLost
+		}
+
+		file.Status.LeaseUntil = time.Now().Add(lease)
+		return nil
+	})
 
-				for _, item := range items {
-					ch <- item
+	if err == engine.ErrNotFound {
+		return engine.ErrLeaseLost
+	}
+
+	return err
+}
+
+// Delete - удаляет файл из очереди, списков мертвых и завершенных
+// задач в случае его присутствия в них
+//
+// Загружаемый файл с действующей арендой не удаляется, возвращается ErrActive
+func (q *Queue) Delete(id string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(id)
+
+		for _, name := range [][]byte{q.buckets.queue, q.buckets.dead, q.buckets.done} {
+			bucket := tx.Bucket(name)
+			if bucket == nil || bucket.Get(key) == nil {
+				continue
+			}
+
+			file, err := get(bucket, id)
+			if err != nil {
+				return err
+			}
+
+			if file.Status.State == engine.StateActive && file.Status.LeaseUntil.After(time.Now()) {
+				return engine.ErrActive
+			}
+
+			err = bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Fail - фиксирует неудачный цикл загрузки файла,
+// файл будет выдан повторно не ранее next
+func (q *Queue) Fail(id string, reason error, next time.Time) error {
+	return q.update(id, func(file *engine.File) error {
+		file.Status.State = engine.StateFailed
+		file.Status.Owner = ""
+		file.Status.LeaseUntil = time.Time{}
+		file.Status.Attempts++
+		file.Status.LastError = errorText(reason)
+		file.Status.NextAt = next
+		return nil
+	})
+}
+
+// SetPriority - изменяет приоритет файла в очереди
+func (q *Queue) SetPriority(id string, priority int) error {
+	return q.update(id, func(file *engine.File) error {
+		file.Priority = priority
+		return nil
+	})
+}
+
+// Release - возвращает прерванный файл в состояние ожидания
+// без учета неудачной попытки
+func (q *Queue) Release(id string) error {
+	return q.update(id, func(file *engine.File) error {
+		file.Status.State = engine.StatePending
+		file.Status.Owner = ""
+		file.Status.LeaseUntil = time.Time{}
+		return nil
+	})
+}
+
+// Bury - фиксирует последний неудачный цикл загрузки файла
+// и переносит его в список мертвых задач
+func (q *Queue) Bury(id string, reason error) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.queue)
+		if bucket == nil {
+			return engine.ErrNotFound
+		}
+
+		file, err := get(bucket, id)
+		if err != nil {
+			return err
+		}
+
+		dead, err := tx.CreateBucketIfNotExists(q.buckets.dead)
+		if err != nil {
+			return err
+		}
+
+		file.Status.State = engine.StateDead
+		file.Status.Attempts++
+		file.Status.LastError = errorText(reason)
+		file.Status.NextAt = time.Time{}
+		file.Status.UpdatedAt = time.Now()
+
+		err = put(dead, *file)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// Retry - возвращает неудачный или мертвый файл в ожидание,
+// счетчик попыток при этом сбрасывается
+func (q *Queue) Retry(id string) error {
+	defer q.notify()
+
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(q.buckets.queue)
+		if err != nil {
+			return err
+		}
+
+		file, err := get(bucket, id)
+		if err == engine.ErrNotFound {
+			if dead := tx.Bucket(q.buckets.dead); dead != nil {
+				file, err = get(dead, id)
+				if err == nil {
+					err = dead.Delete([]byte(id))
 				}
-			default:
-				time.Sleep(time.Millisecond * 100)
 			}
 		}
-	}()
 
-	return ch
+		if err != nil {
+			return err
+		}
+
+		if file.Status.State == engine.StateActive {
+			return engine.ErrActive
+		}
+
+		file.Status = engine.Status{
+			State:     engine.StatePending,
+			UpdatedAt: time.Now(),
+		}
+
+		return put(bucket, *file)
+	})
 }
 
-// Delete - удаляет файл из очереди
-// в случае его присутствия в очереди
-func (q *Queue) Delete(id string) error {
+// Done - фиксирует успешную загрузку файла исполнителем owner
+// и переносит его из очереди в список завершенных задач
+//
+// Возвращает ErrLeaseLost, если файл не выдан owner или удален из очереди
+func (q *Queue) Done(id string, owner string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.queue)
+		if bucket == nil {
+			return engine.ErrLeaseLost
+		}
+
+		file, err := get(bucket, id)
+		if err == engine.ErrNotFound || err == nil && !leased(file, owner) {
+			return engine.ErrLeaseLost
+		}
+
+		if err != nil {
+			return err
+		}
+
+		done, err := tx.CreateBucketIfNotExists(q.buckets.done)
+		if err != nil {
+			return err
+		}
+
+		file.Status.State = engine.StateDone
+		file.Status.Owner = ""
+		file.Status.LeaseUntil = time.Time{}
+		file.Status.NextAt = time.Time{}
+		file.Status.UpdatedAt = time.Now()
+
+		err = put(done, *file)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// Dead - возвращает список мертвых задач
+func (q *Queue) Dead() ([]engine.File, error) {
+	return q.files(q.buckets.dead)
+}
+
+// Completed - возвращает список завершенных задач
+func (q *Queue) Completed() ([]engine.File, error) {
+	return q.files(q.buckets.done)
+}
+
+// files - возвращает все файлы корзины name
+func (q *Queue) files(name []byte) ([]engine.File, error) {
+	var result []engine.File
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(name)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var file engine.File
+			err := json.NewDecoder(bytes.NewReader(v)).Decode(&file)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, file)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// Remember - сохраняет запись о загруженном файле в журнал
+func (q *Queue) Remember(record engine.Record) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(q.buckets.history)
+		if err != nil {
+			return err
+		}
+
+		buf := new(bytes.Buffer)
+		err = json.NewEncoder(buf).Encode(record)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(record.ID), buf.Bytes())
+	})
+}
+
+// Recall - возвращает запись о загруженном файле из журнала
+// в случае ее отсутствия возвращает engine.ErrNotFound
+func (q *Queue) Recall(id string) (*engine.Record, error) {
+	var record engine.Record
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.history)
+		if bucket == nil {
+			return engine.ErrNotFound
+		}
+
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return engine.ErrNotFound
+		}
+
+		return json.NewDecoder(bytes.NewReader(value)).Decode(&record)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// Records - возвращает все записи журнала загруженных файлов
+func (q *Queue) Records() ([]engine.Record, error) {
+	var result []engine.Record
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.history)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var record engine.Record
+			err := json.NewDecoder(bytes.NewReader(v)).Decode(&record)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, record)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// Forget - удаляет запись о загруженном файле из журнала
+func (q *Queue) Forget(id string) error {
 	return q.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(queueBucket)
+		bucket := tx.Bucket(q.buckets.history)
 		if bucket == nil {
 			return nil
 		}
 
-		key := []byte(id)
-		if bucket.Get(key) == nil {
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// ScannedDir - возвращает состояние директории после последнего сканирования
+// в случае его отсутствия возвращает (nil, nil)
+func (q *Queue) ScannedDir(dir string) (*engine.DirState, error) {
+	var state *engine.DirState
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.scan)
+		if bucket == nil {
 			return nil
 		}
 
-		return bucket.Delete(key)
+		value := bucket.Get([]byte(dir))
+		if value == nil {
+			return nil
+		}
+
+		state = new(engine.DirState)
+		return json.NewDecoder(bytes.NewReader(value)).Decode(state)
 	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// SaveDirs - сохраняет состояния директорий одной транзакцией
+//
+// Пустое состояние удаляет директорию и все вложенные директории
+func (q *Queue) SaveDirs(states map[string]*engine.DirState) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(q.buckets.scan)
+		if err != nil {
+			return err
+		}
+
+		for dir, state := range states {
+			if state != nil {
+				continue
+			}
+
+			// Ключи собираются заранее, удаление во время
+			// обхода курсором пропускает элементы
+			prefix := []byte(strings.TrimSuffix(dir, "/") + "/")
+			keys := [][]byte{[]byte(dir)}
+
+			c := bucket.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			for _, key := range keys {
+				err = bucket.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for dir, state := range states {
+			if state == nil {
+				continue
+			}
+
+			buf := new(bytes.Buffer)
+			err = json.NewEncoder(buf).Encode(state)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(dir), buf.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// claim - выбирает первый готовый файл согласно order и выдает его owner
+//
+// Если готовых файлов нет, возвращает время, когда имеет смысл
+// проверить очередь повторно
+//
+// Очередь просматривается в транзакции чтения, которая не блокирует
+// другие изменения, а транзакция записи изменяет только выбранный файл
+func (q *Queue) claim(owner string, order engine.Order, lease time.Duration) (*engine.File, time.Time, error) {
+	for {
+		now := time.Now()
+
+		candidate, next, err := q.candidate(order, now)
+		if err != nil || candidate == nil {
+			return nil, next, err
+		}
+
+		var result *engine.File
+		err = q.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(q.buckets.queue)
+			if bucket == nil {
+				return nil
+			}
+
+			file, err := get(bucket, candidate.ID)
+			if errors.Is(err, engine.ErrNotFound) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			// Файл мог быть выдан другому исполнителю или удален
+			// после просмотра очереди, тогда выбирается следующий
+			if !file.Status.Ready(now) {
+				return nil
+			}
+
+			file.Status.State = engine.StateActive
+			file.Status.Owner = owner
+			file.Status.LeaseUntil = now.Add(lease)
+			file.Status.UpdatedAt = now
+
+			result = file
+			return put(bucket, *file)
+		})
+
+		if err != nil || result != nil {
+			return result, next, err
+		}
+	}
+}
+
+// candidate - возвращает первый готовый к выдаче файл согласно order
+// и время ближайшей повторной попытки или истечения аренды
+func (q *Queue) candidate(order engine.Order, now time.Time) (*engine.File, time.Time, error) {
+	const idle = time.Minute
+
+	var (
+		next   = now.Add(idle)
+		result *engine.File
+	)
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.queue)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var file engine.File
+			err := json.NewDecoder(bytes.NewReader(v)).Decode(&file)
+			if err != nil {
+				return err
+			}
+
+			if file.Status.Ready(now) {
+				// При равенстве выдается файл, записанный раньше
+				if result == nil || engine.CompareFiles(file, *result, order) < 0 {
+					result = &file
+				}
+
+				return nil
+			}
+
+			// Ближайшая повторная попытка или истечение аренды
+			for _, at := range []time.Time{file.Status.NextAt, file.Status.LeaseUntil} {
+				if at.After(now) && at.Before(next) {
+					next = at
+				}
+			}
+
+			return nil
+		})
+	})
+
+	return result, next, err
+}
+
+// waiter - возвращает канал, который будет закрыт
+// при следующем изменении очереди
+func (q *Queue) waiter() <-chan struct{} {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	return q.wake
+}
+
+// notify - будит исполнителей, ожидающих файлы
+func (q *Queue) notify() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// update - изменяет запись файла в очереди
+//
+// Ожидающие исполнители проверяют очередь повторно,
+// так как файл мог стать готовым к выдаче
+func (q *Queue) update(id string, fn func(file *engine.File) error) error {
+	defer q.notify()
+
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.buckets.queue)
+		if bucket == nil {
+			return engine.ErrNotFound
+		}
+
+		file, err := get(bucket, id)
+		if err != nil {
+			return err
+		}
+
+		err = fn(file)
+		if err != nil {
+			return err
+		}
+
+		file.Status.UpdatedAt = time.Now()
+		return put(bucket, *file)
+	})
+}
+
+// leased - проверяет, что файл выдан исполнителю owner
+func leased(file *engine.File, owner string) bool {
+	return file.Status.State == engine.StateActive && file.Status.Owner == owner
+}
+
+// recoverActive - возвращает активные файлы в состояние ожидания
+func recoverActive(bucket *bolt.Bucket) error {
+	var files []engine.File
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		var file engine.File
+		err := json.NewDecoder(bytes.NewReader(v)).Decode(&file)
+		if err != nil {
+			return err
+		}
+
+		if file.Status.State == engine.StateActive {
+			files = append(files, file)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		file.Status.State = engine.StatePending
+		file.Status.Owner = ""
+		file.Status.LeaseUntil = time.Time{}
+		file.Status.UpdatedAt = time.Now()
+
+		err = put(bucket, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func get(bucket *bolt.Bucket, id string) (*engine.File, error) {
+	value := bucket.Get([]byte(id))
+	if value == nil {
+		return nil, engine.ErrNotFound
+	}
+
+	var file engine.File
+	err := json.NewDecoder(bytes.NewReader(value)).Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+func put(bucket *bolt.Bucket, file engine.File) error {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(file)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(file.ID), buf.Bytes())
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
 }
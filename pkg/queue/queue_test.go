@@ -2,12 +2,14 @@ package queue_test
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ReanSn0w/wddl/pkg/engine"
 	"github.com/ReanSn0w/wddl/pkg/queue"
-	"github.com/go-pkgz/lgr"
 )
 
 func TestNew(t *testing.T) {
@@ -295,7 +297,7 @@ func TestDelete(t *testing.T) {
 	}
 }
 
-func TestChan(t *testing.T) {
+func TestClaim(t *testing.T) {
 	tmpFile := t.TempDir() + "/test.db"
 	q, err := queue.New(tmpFile)
 	if err != nil {
@@ -307,7 +309,6 @@ func TestChan(t *testing.T) {
 		{ID: "file2", Size: 2048},
 	}
 
-	// Добавляем файлы до подписки
 	for _, file := range files {
 		err = q.Add(file)
 		if err != nil {
@@ -318,31 +319,158 @@ func TestChan(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	logger := lgr.New()
+	// Каждый файл выдается только одному исполнителю
+	first, err := q.Claim(ctx, "worker1", engine.OrderNone, time.Hour)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
 
-	results := make([]engine.File, 0)
-	resultsCh := q.Chan(ctx, logger, nil)
+	second, err := q.Claim(ctx, "worker2", engine.OrderNone, time.Hour)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
 
-	// Собираем результаты в течение 5 секунд
-	timeout := time.NewTimer(5 * time.Second)
+	if first.ID == second.ID {
+		t.Fatalf("Claim() returned %s twice", first.ID)
+	}
 
-	for {
-		select {
-		case file, ok := <-resultsCh:
-			if !ok {
-				return
-			}
-			results = append(results, file)
-			if len(results) >= 2 {
+	if first.Status.State != engine.StateActive || first.Status.Owner != "worker1" || first.Status.LeaseUntil.IsZero() {
+		t.Errorf("Claim() status = %+v", first.Status)
+	}
+
+	// Ожидающий исполнитель получает файл сразу после добавления
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = q.Add(engine.File{ID: "file3", Size: 512})
+	}()
+
+	started := time.Now()
+	third, err := q.Claim(ctx, "worker3", engine.OrderNone, time.Hour)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	if third.ID != "file3" {
+		t.Errorf("Claim() = %s, want file3", third.ID)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Claim() waited %v for added file", elapsed)
+	}
+
+	// Пустая очередь блокирует до завершения контекста
+	short, cancelShort := context.WithTimeout(ctx, time.Millisecond*200)
+	defer cancelShort()
+
+	if _, err := q.Claim(short, "worker4", engine.OrderNone, time.Hour); err != context.DeadlineExceeded {
+		t.Errorf("Claim() on empty queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClaimConcurrent(t *testing.T) {
+	q, err := queue.New(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	defer q.Close()
+
+	const count = 40
+	for i := range count {
+		if err := q.Add(engine.File{ID: fmt.Sprintf("file%d", i), Size: int64(i)}); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mx      sync.Mutex
+		claimed = make(map[string]string)
+	)
+
+	for worker := range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			owner := fmt.Sprintf("worker%d", worker)
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+				file, err := q.Claim(ctx, owner, engine.OrderSmallest, time.Hour)
 				cancel()
+				if err != nil {
+					return
+				}
+
+				mx.Lock()
+				if other, ok := claimed[file.ID]; ok {
+					t.Errorf("%s claimed by %s and %s", file.ID, other, owner)
+				}
+				claimed[file.ID] = owner
+				mx.Unlock()
 			}
-		case <-timeout.C:
-			if len(results) < 2 {
-				t.Logf("Chan() returned %d files in 5 seconds, expected at least 2", len(results))
-			}
+		}()
+	}
 
-			return
-		}
+	wg.Wait()
+
+	if len(claimed) != count {
+		t.Errorf("claimed %d files, want %d", len(claimed), count)
+	}
+}
+
+func TestLease(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	err = q.Add(engine.File{ID: "file1", Size: 1024})
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := q.Claim(ctx, "worker1", engine.OrderNone, time.Millisecond*200); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	if err := q.Renew("file1", "worker1", time.Millisecond*200); err != nil {
+		t.Errorf("Renew() error = %v", err)
+	}
+
+	// После истечения аренды файл выдается повторно
+	file, err := q.Claim(ctx, "worker2", engine.OrderNone, time.Hour)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	if file.ID != "file1" || file.Status.Owner != "worker2" {
+		t.Errorf("Claim() = %s owned by %s, want file1 owned by worker2", file.ID, file.Status.Owner)
+	}
+
+	if err := q.Renew("file1", "worker1", time.Hour); err != engine.ErrLeaseLost {
+		t.Errorf("Renew() by previous owner error = %v, want %v", err, engine.ErrLeaseLost)
+	}
+
+	// Файл с действующей арендой не удаляется
+	if err := q.Delete("file1"); err != engine.ErrActive {
+		t.Errorf("Delete() of leased file error = %v, want %v", err, engine.ErrActive)
+	}
+
+	if err := q.Release("file1"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	if err := q.Delete("file1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if err := q.Renew("file1", "worker2", time.Hour); err != engine.ErrLeaseLost {
+		t.Errorf("Renew() of deleted file error = %v, want %v", err, engine.ErrLeaseLost)
 	}
 }
 
@@ -400,3 +528,514 @@ func TestIntegration(t *testing.T) {
 		t.Errorf("Expected 2 files in list, got %d", len(list))
 	}
 }
+
+func TestPartitionSizePersisted(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	conf := engine.Config{InputPath: "/in", OutputPath: "/out", PartitionSize: 8 << 20}
+	err = q.Add(engine.NewFile(conf, "/in/file.bin", 1024))
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	list, err := q.List(nil)
+	if err != nil {
+		t.Errorf("List() error = %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("Expected 1 file in list, got %d", len(list))
+	}
+	if list[0].PartitionSize != 8<<20 {
+		t.Errorf("PartitionSize = %d, want %d", list[0].PartitionSize, 8<<20)
+	}
+}
+
+func TestStateMachine(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	err = q.Add(engine.File{ID: "file1", Size: 1024})
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	state := func() engine.Status {
+		list, err := q.List(nil)
+		if err != nil || len(list) != 1 {
+			t.Fatalf("List() = %v, %v", list, err)
+		}
+		return list[0].Status
+	}
+
+	if s := state(); s.State != engine.StatePending {
+		t.Errorf("State after Add() = %s, want %s", s.State, engine.StatePending)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := q.Claim(ctx, "worker", engine.OrderNone, time.Hour); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	if s := state(); s.State != engine.StateActive || s.Ready(time.Now()) {
+		t.Errorf("State after Claim() = %s, ready %v", s.State, s.Ready(time.Now()))
+	}
+
+	next := time.Now().Add(time.Hour)
+	if err := q.Fail("file1", engine.ErrNotFound, next); err != nil {
+		t.Errorf("Fail() error = %v", err)
+	}
+
+	s := state()
+	if s.State != engine.StateFailed || s.Attempts != 1 || s.LastError != engine.ErrNotFound.Error() {
+		t.Errorf("Status after Fail() = %+v", s)
+	}
+	if s.Ready(time.Now()) {
+		t.Error("Failed file should not be ready before NextAt")
+	}
+	if !s.Ready(next.Add(time.Second)) {
+		t.Error("Failed file should be ready after NextAt")
+	}
+
+	if err := q.Retry("file1"); err != nil {
+		t.Errorf("Retry() error = %v", err)
+	}
+	if _, err := q.Claim(ctx, "worker", engine.OrderNone, time.Hour); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	if err := q.Release("file1"); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+	if s := state(); s.State != engine.StatePending || s.Owner != "" || !s.Ready(time.Now()) {
+		t.Errorf("Status after Release() = %+v", s)
+	}
+
+	if err := q.Release("nonexistent"); err == nil {
+		t.Error("Release() on missing file should fail")
+	}
+}
+
+func TestBury(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	err = q.Add(engine.File{ID: "file1", Size: 1024})
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	if err := q.Bury("file1", engine.ErrNotFound); err != nil {
+		t.Fatalf("Bury() error = %v", err)
+	}
+
+	l, err := q.Len()
+	if err != nil || l != 0 {
+		t.Errorf("Len() = %d, %v, want 0", l, err)
+	}
+
+	// Мертвая задача не должна повторно попадать в очередь
+	if err := q.Exists("file1"); err != nil {
+		t.Errorf("Exists() error = %v, want nil for dead file", err)
+	}
+
+	dead, err := q.Dead()
+	if err != nil {
+		t.Errorf("Dead() error = %v", err)
+	}
+	if len(dead) != 1 || dead[0].Status.State != engine.StateDead || dead[0].Status.Attempts != 1 {
+		t.Errorf("Dead() = %+v", dead)
+	}
+
+	stat, err := q.Stat()
+	if err != nil {
+		t.Errorf("Stat() error = %v", err)
+	}
+	if stat.Dead != 1 || stat.Files != 0 {
+		t.Errorf("Stat() = %+v", stat)
+	}
+}
+
+func TestDone(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	err = q.Add(engine.File{ID: "file1", Size: 1024})
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := q.Claim(ctx, "worker", engine.OrderNone, time.Hour); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	if err := q.Done("file1", "other"); err != engine.ErrLeaseLost {
+		t.Errorf("Done() by another worker error = %v, want lease lost", err)
+	}
+
+	if err := q.Done("file1", "worker"); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+
+	if err := q.Done("file1", "worker"); err != engine.ErrLeaseLost {
+		t.Errorf("Done() of finished file error = %v, want lease lost", err)
+	}
+
+	if l, err := q.Len(); err != nil || l != 0 {
+		t.Errorf("Len() = %d, %v, want 0", l, err)
+	}
+
+	done, err := q.Completed()
+	if err != nil || len(done) != 1 || done[0].Status.State != engine.StateDone || done[0].Status.Owner != "" {
+		t.Errorf("Completed() = %+v, %v", done, err)
+	}
+
+	stat, err := q.Stat()
+	if err != nil || stat.Done != 1 || stat.Files != 0 {
+		t.Errorf("Stat() = %+v, %v", stat, err)
+	}
+
+	// Повторно добавленный файл снова ожидает загрузки
+	if err := q.Add(engine.File{ID: "file1", Size: 1024}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	if done, _ := q.Completed(); len(done) != 0 {
+		t.Errorf("Completed() after Add() = %+v, want empty", done)
+	}
+
+	if _, err := q.Claim(ctx, "worker", engine.OrderNone, time.Hour); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	if err := q.Done("file1", "worker"); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+
+	if err := q.Delete("file1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if done, _ := q.Completed(); len(done) != 0 {
+		t.Errorf("Completed() after Delete() = %+v, want empty", done)
+	}
+}
+
+func TestRecoverActive(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	err = q.Add(engine.File{ID: "file1", Size: 1024})
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := q.Claim(ctx, "worker", engine.OrderNone, time.Hour); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// База, открытая только для чтения, не изменяется
+	ro, err := queue.NewReadOnly(tmpFile, "")
+	if err != nil {
+		t.Fatalf("NewReadOnly() error = %v", err)
+	}
+
+	list, err := ro.List(nil)
+	if err != nil || len(list) != 1 || list[0].Status.State != engine.StateActive {
+		t.Errorf("read-only List() = %+v, %v, want one active file", list, err)
+	}
+
+	if err := ro.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	q, err = queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+
+	list, err = q.List(nil)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List() = %v, %v", list, err)
+	}
+	if list[0].Status.State != engine.StatePending {
+		t.Errorf("State after reopen = %s, want %s", list[0].Status.State, engine.StatePending)
+	}
+}
+
+func TestReadOnlyMissing(t *testing.T) {
+	path := t.TempDir() + "/missing.db"
+
+	q, err := queue.NewReadOnly(path, "movies")
+	if err != nil {
+		t.Fatalf("NewReadOnly() error = %v", err)
+	}
+
+	if err := q.Exists("file1"); err != engine.ErrNotFound {
+		t.Errorf("Exists() error = %v, want not found", err)
+	}
+
+	if records, err := q.Records(); err != nil || len(records) != 0 {
+		t.Errorf("Records() = %v, %v, want empty", records, err)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("NewReadOnly() should not create database, stat error = %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	_, err = q.Recall("file1")
+	if err != engine.ErrNotFound {
+		t.Errorf("Recall() error = %v, want %v", err, engine.ErrNotFound)
+	}
+
+	record := engine.Record{
+		ID:          "file1",
+		Source:      "/remote/file1",
+		Size:        1024,
+		Dest:        "/local/file1",
+		Checksum:    "abc",
+		CompletedAt: time.Now().Truncate(time.Second),
+	}
+
+	if err := q.Remember(record); err != nil {
+		t.Fatalf("Remember() error = %v", err)
+	}
+
+	got, err := q.Recall("file1")
+	if err != nil {
+		t.Fatalf("Recall() error = %v", err)
+	}
+
+	if got.Source != record.Source || got.Checksum != record.Checksum || !got.CompletedAt.Equal(record.CompletedAt) {
+		t.Errorf("Recall() = %+v, want %+v", got, record)
+	}
+
+	// Журнал не влияет на наличие файла в очереди
+	if err := q.Exists("file1"); err != engine.ErrNotFound {
+		t.Errorf("Exists() error = %v, want %v", err, engine.ErrNotFound)
+	}
+
+	records, err := q.Records()
+	if err != nil || len(records) != 1 || records[0].ID != "file1" {
+		t.Errorf("Records() = %+v, %v", records, err)
+	}
+
+	if err := q.Forget("file1"); err != nil {
+		t.Fatalf("Forget() error = %v", err)
+	}
+
+	if _, err := q.Recall("file1"); err != engine.ErrNotFound {
+		t.Errorf("Recall() after Forget() error = %v, want %v", err, engine.ErrNotFound)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	for _, id := range []string{"failed", "dead", "active"} {
+		err = q.Add(engine.File{ID: id, Size: 1024})
+		if err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+
+	_ = q.Fail("failed", engine.ErrNotFound, time.Now().Add(time.Hour))
+	_ = q.Bury("dead", engine.ErrNotFound)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = q.Claim(ctx, "worker", engine.OrderNone, time.Hour)
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{name: "Retry failed file", id: "failed"},
+		{name: "Retry dead file", id: "dead"},
+		{name: "Retry active file", id: "active", wantErr: engine.ErrActive},
+		{name: "Retry missing file", id: "nonexistent", wantErr: engine.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := q.Retry(tt.id)
+			if err != tt.wantErr {
+				t.Errorf("Retry() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	list, err := q.List(func(f engine.File) error {
+		if !f.Status.Ready(time.Now()) {
+			return engine.ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("List() error = %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("Expected 2 ready files after retry, got %d", len(list))
+	}
+
+	dead, _ := q.Dead()
+	if len(dead) != 0 {
+		t.Errorf("Expected empty dead list after retry, got %d", len(dead))
+	}
+}
+
+func TestClaimOrder(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	for _, file := range []engine.File{
+		{ID: "a", Size: 3000},
+		{ID: "b", Size: 1000},
+		{ID: "c", Size: 2000},
+		{ID: "d", Size: 4000},
+	} {
+		if err := q.Add(file); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+
+	if err := q.SetPriority("d", 10); err != nil {
+		t.Fatalf("SetPriority() error = %v", err)
+	}
+	if err := q.SetPriority("nonexistent", 10); err != engine.ErrNotFound {
+		t.Errorf("SetPriority() on missing file error = %v, want %v", err, engine.ErrNotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	want := []string{"d", "b", "c", "a"}
+	for i, id := range want {
+		file, err := q.Claim(ctx, "worker", engine.OrderSmallest, time.Hour)
+		if err != nil {
+			t.Fatalf("Claim() returned only %d files: %v", i, err)
+		}
+
+		if file.ID != id {
+			t.Fatalf("Claim() item %d = %s, want %s", i, file.ID, id)
+		}
+	}
+}
+
+func TestLocked(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	defer q.Close()
+
+	if _, err := queue.New(tmpFile); err != queue.ErrLocked {
+		t.Errorf("New() on locked database error = %v, want %v", err, queue.ErrLocked)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	tmpFile := t.TempDir() + "/test.db"
+	db, err := queue.Open(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	photos, err := db.Queue("photos")
+	if err != nil {
+		t.Fatalf("Queue(photos) error = %v", err)
+	}
+
+	docs, err := db.Queue("docs")
+	if err != nil {
+		t.Fatalf("Queue(docs) error = %v", err)
+	}
+
+	file := engine.File{ID: "same", Name: "a.jpg", Size: 10}
+	if err := photos.Add(file); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	if err := photos.Remember(engine.Record{ID: "same"}); err != nil {
+		t.Fatalf("Remember() error = %v", err)
+	}
+
+	if err := docs.Exists("same"); err != engine.ErrNotFound {
+		t.Errorf("file of another job should not be visible, Exists() error = %v", err)
+	}
+
+	if _, err := docs.Recall("same"); err != engine.ErrNotFound {
+		t.Errorf("history of another job should not be visible, Recall() error = %v", err)
+	}
+
+	// Закрытие очереди задания не закрывает общую базу данных
+	if err := photos.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if n, err := docs.Len(); err != nil || n != 0 {
+		t.Errorf("Len() = %d, %v after closing another job", n, err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("DB.Close() error = %v", err)
+	}
+
+	q, err := queue.New(tmpFile)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	defer q.Close()
+
+	if err := q.Exists("same"); err != engine.ErrNotFound {
+		t.Errorf("default queue should not see job files, Exists() error = %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Package retry классифицирует ошибки удаленного хранилища
+// и извлекает из них задержку, запрошенную сервером
+package retry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// Class - класс ошибки удаленного хранилища
+type Class string
+
+const (
+	// Сервер ограничивает частоту запросов (429, 503)
+	ClassThrottled Class = "throttled"
+
+	// Неверные учетные данные или нет доступа (401, 403)
+	ClassAuth Class = "auth"
+
+	// Файл отсутствует на сервере (404, 410)
+	ClassNotFound Class = "not found"
+
+	// Внутренняя ошибка сервера (5xx)
+	ClassServer Class = "server"
+
+	// Соединение разорвано, не установлено или истекло время ожидания
+	ClassNetwork Class = "network"
+
+	// Остальные ошибки, в том числе неполный ответ
+	ClassUnknown Class = "unknown"
+)
+
+// Retryable - проверяет, имеет ли смысл повторять запрос
+//
+// Ошибки доступа и отсутствие файла повтором не исправить
+func (c Class) Retryable() bool {
+	return c != ClassAuth && c != ClassNotFound
+}
+
+// Classify - определяет класс ошибки
+func Classify(err error) Class {
+	if status := Status(err); status != 0 {
+		switch {
+		case status == http.StatusTooManyRequests, status == http.StatusServiceUnavailable:
+			return ClassThrottled
+		case status == http.StatusUnauthorized, status == http.StatusForbidden:
+			return ClassAuth
+		case status == http.StatusNotFound, status == http.StatusGone:
+			return ClassNotFound
+		case status >= 500:
+			return ClassServer
+		default:
+			return ClassUnknown
+		}
+	}
+
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return ClassAuth
+	case errors.Is(err, os.ErrNotExist):
+		return ClassNotFound
+	case errors.As(err, &netErr),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return ClassNetwork
+	default:
+		return ClassUnknown
+	}
+}
+
+// Status - возвращает код ответа сервера, вызвавшего ошибку, 0 если его нет
+func Status(err error) int {
+	var status gowebdav.StatusError
+	if errors.As(err, &status) {
+		return status.Status
+	}
+
+	return 0
+}
+
+// Error - ошибка, ответ на которую содержал заголовок Retry-After
+type Error struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error() + " (retry after " + e.After.String() + ")"
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithAfter - дополняет ошибку задержкой, запрошенной сервером
+// в заголовке Retry-After ответа resp
+func WithAfter(err error, resp *http.Response) error {
+	after := ParseAfter(resp.Header.Get("Retry-After"), time.Now())
+	if after <= 0 {
+		return err
+	}
+
+	return &Error{Err: err, After: after}
+}
+
+// After - возвращает задержку, запрошенную сервером, 0 если ее нет
+func After(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.After
+	}
+
+	return 0
+}
+
+// ParseAfter - разбирает значение заголовка Retry-After: количество
+// секунд или дату (RFC 9110), 0 если значение пустое или неверное
+func ParseAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+
+	return max(at.Sub(now), 0)
+}
@@ -0,0 +1,68 @@
+package retry_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/retry"
+	"github.com/studio-b12/gowebdav"
+)
+
+func TestClassify(t *testing.T) {
+	status := func(code int) error {
+		return fmt.Errorf("failed to read partition: %w", gowebdav.NewPathError("ReadStream", "/a.bin", code))
+	}
+
+	tests := []struct {
+		err       error
+		want      retry.Class
+		retryable bool
+	}{
+		{err: status(http.StatusTooManyRequests), want: retry.ClassThrottled, retryable: true},
+		{err: status(http.StatusServiceUnavailable), want: retry.ClassThrottled, retryable: true},
+		{err: status(http.StatusUnauthorized), want: retry.ClassAuth},
+		{err: status(http.StatusForbidden), want: retry.ClassAuth},
+		{err: status(http.StatusNotFound), want: retry.ClassNotFound},
+		{err: status(http.StatusBadGateway), want: retry.ClassServer, retryable: true},
+		{err: status(http.StatusConflict), want: retry.ClassUnknown, retryable: true},
+		{err: &os.PathError{Op: "GET", Path: "/a.bin", Err: &url.Error{Op: "Get", URL: "/a.bin", Err: syscall.ECONNREFUSED}}, want: retry.ClassNetwork, retryable: true},
+		{err: fmt.Errorf("copy: %w", io.ErrUnexpectedEOF), want: retry.ClassNetwork, retryable: true},
+		{err: fmt.Errorf("stat: %w", os.ErrNotExist), want: retry.ClassNotFound},
+		{err: errors.New("partition 1 is incomplete"), want: retry.ClassUnknown, retryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			got := retry.Classify(tt.err)
+			if got != tt.want || got.Retryable() != tt.retryable {
+				t.Errorf("Classify() = %s (retryable %v), want %s (retryable %v)", got, got.Retryable(), tt.want, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestParseAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Duration{
+		"":                              0,
+		"30":                            30 * time.Second,
+		"-5":                            0,
+		"soon":                          0,
+		"Wed, 01 May 2024 12:02:00 GMT": 2 * time.Minute,
+		"Wed, 01 May 2024 11:00:00 GMT": 0,
+	}
+
+	for value, want := range tests {
+		if got := retry.ParseAfter(value, now); got != want {
+			t.Errorf("ParseAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/limiter"
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+	"github.com/go-pkgz/lgr"
+)
+
+type Engine interface {
+	Pause()
+	Resume()
+	Rescan()
+	Active() []engine.Progress
+	Report() (*engine.Report, error)
+}
+
+type Queue interface {
+	List(filter func(f engine.File) error) ([]engine.File, error)
+	Dead() ([]engine.File, error)
+	Retry(id string) error
+	Delete(id string) error
+	SetPriority(id string, priority int) error
+}
+
+type Bandwidth interface {
+	Set(rate int64)
+	Reset()
+	SetPerFile(rate int64)
+	Rates() (global int64, perFile int64)
+}
+
+func New(log lgr.L, engine Engine, queue Queue, bandwidth Bandwidth) *Server {
+	return &Server{
+		log:       log,
+		engine:    engine,
+		queue:     queue,
+		bandwidth: bandwidth,
+	}
+}
+
+// Server - HTTP API для наблюдения и управления запущенным движком
+type Server struct {
+	log       lgr.L
+	engine    Engine
+	queue     Queue
+	bandwidth Bandwidth
+}
+
+// Start - запускает HTTP сервер на указанном адресе,
+// сервер останавливается при завершении контекста
+func (s *Server) Start(ctx context.Context, addr string) {
+	Serve(ctx, s.log, addr, s.Handler())
+}
+
+// Serve - запускает HTTP сервер с обработчиком handler,
+// сервер останавливается при завершении контекста
+func Serve(ctx context.Context, log lgr.L, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Logf("[ERROR] http server shutdown: %v", err)
+		}
+	}()
+
+	go func() {
+		log.Logf("[INFO] http api listening on %s", addr)
+
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Logf("[ERROR] http server: %v", err)
+		}
+	}()
+}
+
+// Jobs - объединяет API нескольких заданий, API задания доступно
+// по префиксу /jobs/<name>
+//
+// Метрики процесса общие для всех заданий, поэтому они выдаются
+// один раз по /metrics, а значения очередей заданий суммируются
+func Jobs(servers map[string]*Server) http.Handler {
+	mux := http.NewServeMux()
+
+	var (
+		log  lgr.L
+		list = make([]*Server, 0, len(servers))
+	)
+
+	for name, s := range servers {
+		prefix := "/jobs/" + name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, s.api()))
+
+		log = s.log
+		list = append(list, s)
+	}
+
+	mux.Handle("GET /metrics", metrics.Handler(func() { collect(log, list) }))
+
+	return mux
+}
+
+// Handler - возвращает обработчик запросов API и метрик
+func (s *Server) Handler() http.Handler {
+	mux := s.api()
+	mux.Handle("GET /metrics", metrics.Handler(func() { collect(s.log, []*Server{s}) }))
+
+	return mux
+}
+
+// api - возвращает обработчик запросов API без метрик
+func (s *Server) api() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/status", s.status)
+	mux.HandleFunc("GET /api/active", s.active)
+	mux.HandleFunc("GET /api/queue", s.list)
+	mux.HandleFunc("GET /api/dead", s.dead)
+
+	mux.HandleFunc("POST /api/scan", s.scan)
+	mux.HandleFunc("POST /api/pause", s.pause)
+	mux.HandleFunc("POST /api/resume", s.resume)
+	mux.HandleFunc("POST /api/queue/{id}/retry", s.retry)
+	mux.HandleFunc("POST /api/queue/{id}/priority", s.priority)
+	mux.HandleFunc("DELETE /api/queue/{id}", s.drop)
+
+	mux.HandleFunc("GET /api/limit", s.limit)
+	mux.HandleFunc("POST /api/limit", s.setLimit)
+	mux.HandleFunc("DELETE /api/limit", s.resetLimit)
+
+	return mux
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	report, err := s.engine.Report()
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	s.json(w, http.StatusOK, report)
+}
+
+func (s *Server) active(w http.ResponseWriter, r *http.Request) {
+	s.json(w, http.StatusOK, s.engine.Active())
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	files, err := s.queue.List(nil)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	s.json(w, http.StatusOK, files)
+}
+
+func (s *Server) dead(w http.ResponseWriter, r *http.Request) {
+	files, err := s.queue.Dead()
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	s.json(w, http.StatusOK, files)
+}
+
+func (s *Server) scan(w http.ResponseWriter, r *http.Request) {
+	s.engine.Rescan()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.engine.Pause()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.engine.Resume()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) retry(w http.ResponseWriter, r *http.Request) {
+	err := s.queue.Retry(r.PathValue("id"))
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// priority - изменяет приоритет файла в очереди
+// параметры запроса: value (приоритет, больший выдается раньше)
+func (s *Server) priority(w http.ResponseWriter, r *http.Request) {
+	value, err := strconv.Atoi(r.URL.Query().Get("value"))
+	if err != nil {
+		s.json(w, http.StatusBadRequest, map[string]string{"error": "invalid priority value"})
+		return
+	}
+
+	err = s.queue.SetPriority(r.PathValue("id"), value)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) drop(w http.ResponseWriter, r *http.Request) {
+	err := s.queue.Delete(r.PathValue("id"))
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) limit(w http.ResponseWriter, r *http.Request) {
+	global, perFile := s.bandwidth.Rates()
+	s.json(w, http.StatusOK, map[string]int64{
+		"Global":  global,
+		"PerFile": perFile,
+	})
+}
+
+// setLimit - изменяет ограничения скорости
+// параметры запроса: rate (общая скорость), file (скорость одного файла)
+func (s *Server) setLimit(w http.ResponseWriter, r *http.Request) {
+	rates := make(map[string]int64)
+
+	for _, key := range []string{"rate", "file"} {
+		if !r.URL.Query().Has(key) {
+			continue
+		}
+
+		rate, err := limiter.ParseRate(r.URL.Query().Get(key))
+		if err != nil {
+			s.json(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		rates[key] = rate
+	}
+
+	if rate, ok := rates["rate"]; ok {
+		s.bandwidth.Set(rate)
+	}
+
+	if rate, ok := rates["file"]; ok {
+		s.bandwidth.SetPerFile(rate)
+	}
+
+	s.limit(w, r)
+}
+
+// resetLimit - возвращает общую скорость к расписанию
+func (s *Server) resetLimit(w http.ResponseWriter, r *http.Request) {
+	s.bandwidth.Reset()
+	s.limit(w, r)
+}
+
+// collect - обновляет метрики, которые считаются по запросу
+//
+// Очереди заданий суммируются, выдача считается приостановленной,
+// если приостановлены все задания, а окно открытым, если оно
+// открыто хотя бы для одного задания
+func collect(log lgr.L, servers []*Server) {
+	var (
+		queue    engine.Stat
+		paused   = len(servers) > 0
+		inWindow bool
+	)
+
+	for _, s := range servers {
+		report, err := s.engine.Report()
+		if err != nil {
+			log.Logf("[ERROR] failed to collect metrics: %v", err)
+			return
+		}
+
+		queue.Files += report.Queue.Files
+		queue.FullSize += report.Queue.FullSize
+		queue.Failed += report.Queue.Failed
+		queue.Dead += report.Queue.Dead
+
+		paused = paused && report.Paused
+		inWindow = inWindow || report.InWindow
+	}
+
+	metrics.QueueFiles.Set(float64(queue.Files))
+	metrics.QueueBytes.Set(float64(queue.FullSize))
+	metrics.QueueFailed.Set(float64(queue.Failed))
+	metrics.QueueDead.Set(float64(queue.Dead))
+	metrics.Paused.Set(flag(paused))
+	metrics.InWindow.Set(flag(inWindow))
+}
+
+func flag(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func (s *Server) json(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		s.log.Logf("[ERROR] failed to encode response: %v", err)
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, engine.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, engine.ErrActive):
+		code = http.StatusConflict
+	}
+
+	s.json(w, code, map[string]string{"error": err.Error()})
+}
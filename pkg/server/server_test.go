@@ -0,0 +1,372 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/server"
+	"github.com/go-pkgz/lgr"
+)
+
+// fakeEngine - движок, запоминающий вызовы управления
+type fakeEngine struct {
+	paused  bool
+	rescans int
+	report  *engine.Report
+	err     error
+}
+
+func (e *fakeEngine) Pause()  { e.paused = true }
+func (e *fakeEngine) Resume() { e.paused = false }
+func (e *fakeEngine) Rescan() { e.rescans++ }
+
+func (e *fakeEngine) Active() []engine.Progress {
+	return []engine.Progress{{ID: "a", Name: "a.bin", Percent: 50}}
+}
+
+func (e *fakeEngine) Report() (*engine.Report, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	report := *e.report
+	report.Paused = e.paused
+	return &report, nil
+}
+
+// fakeQueue - очередь из файлов files, файл "busy" загружается,
+// остальных файлов в очереди нет
+type fakeQueue struct {
+	files      []engine.File
+	dead       []engine.File
+	retried    []string
+	deleted    []string
+	priorities map[string]int
+	err        error
+}
+
+func (q *fakeQueue) find(id string) error {
+	if id == "busy" {
+		return engine.ErrActive
+	}
+
+	for _, f := range q.files {
+		if f.ID == id {
+			return nil
+		}
+	}
+
+	return engine.ErrNotFound
+}
+
+func (q *fakeQueue) List(filter func(f engine.File) error) ([]engine.File, error) {
+	return q.files, q.err
+}
+
+func (q *fakeQueue) Dead() ([]engine.File, error) {
+	return q.dead, q.err
+}
+
+func (q *fakeQueue) Retry(id string) error {
+	if err := q.find(id); err != nil {
+		return err
+	}
+
+	q.retried = append(q.retried, id)
+	return nil
+}
+
+func (q *fakeQueue) Delete(id string) error {
+	if err := q.find(id); err != nil {
+		return err
+	}
+
+	q.deleted = append(q.deleted, id)
+	return nil
+}
+
+func (q *fakeQueue) SetPriority(id string, priority int) error {
+	if err := q.find(id); err != nil {
+		return err
+	}
+
+	q.priorities[id] = priority
+	return nil
+}
+
+// fakeBandwidth - ограничение скорости, общая скорость которого
+// по расписанию равна scheduled
+type fakeBandwidth struct {
+	scheduled int64
+	global    int64
+	perFile   int64
+}
+
+func (b *fakeBandwidth) Set(rate int64)        { b.global = rate }
+func (b *fakeBandwidth) Reset()                { b.global = b.scheduled }
+func (b *fakeBandwidth) SetPerFile(rate int64) { b.perFile = rate }
+
+func (b *fakeBandwidth) Rates() (int64, int64) {
+	return b.global, b.perFile
+}
+
+type fixture struct {
+	engine    *fakeEngine
+	queue     *fakeQueue
+	bandwidth *fakeBandwidth
+	server    *server.Server
+}
+
+func newFixture(files int64) *fixture {
+	f := &fixture{
+		engine: &fakeEngine{report: &engine.Report{InWindow: true, Queue: engine.Stat{Files: int(files), FullSize: files * 100}}},
+		queue: &fakeQueue{
+			files:      []engine.File{{ID: "a", Name: "a.bin"}, {ID: "b", Name: "b.bin"}},
+			dead:       []engine.File{{ID: "d", Name: "d.bin"}},
+			priorities: map[string]int{},
+		},
+		bandwidth: &fakeBandwidth{scheduled: 1 << 20, global: 1 << 20},
+	}
+
+	f.server = server.New(lgr.NoOp, f.engine, f.queue, f.bandwidth)
+	return f
+}
+
+func request(t *testing.T, handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var value T
+	if err := json.NewDecoder(rec.Body).Decode(&value); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+
+	return value
+}
+
+func TestStatus(t *testing.T) {
+	f := newFixture(3)
+	handler := f.server.Handler()
+
+	rec := request(t, handler, http.MethodGet, "/api/status")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/status code = %d, want 200", rec.Code)
+	}
+
+	report := decode[engine.Report](t, rec)
+	if !report.InWindow || report.Queue.Files != 3 || report.Queue.FullSize != 300 {
+		t.Errorf("GET /api/status = %+v", report)
+	}
+
+	f.engine.err = errors.New("database is closed")
+
+	rec = request(t, handler, http.MethodGet, "/api/status")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("GET /api/status with engine error code = %d, want 500", rec.Code)
+	}
+
+	if body := decode[map[string]string](t, rec); body["error"] != "database is closed" {
+		t.Errorf("GET /api/status error = %v", body)
+	}
+}
+
+func TestLists(t *testing.T) {
+	f := newFixture(2)
+	handler := f.server.Handler()
+
+	rec := request(t, handler, http.MethodGet, "/api/active")
+	if active := decode[[]engine.Progress](t, rec); rec.Code != http.StatusOK || len(active) != 1 || active[0].Percent != 50 {
+		t.Errorf("GET /api/active = %d %+v", rec.Code, active)
+	}
+
+	rec = request(t, handler, http.MethodGet, "/api/queue")
+	if files := decode[[]engine.File](t, rec); rec.Code != http.StatusOK || len(files) != 2 || files[1].ID != "b" {
+		t.Errorf("GET /api/queue = %d %+v", rec.Code, files)
+	}
+
+	rec = request(t, handler, http.MethodGet, "/api/dead")
+	if files := decode[[]engine.File](t, rec); rec.Code != http.StatusOK || len(files) != 1 || files[0].ID != "d" {
+		t.Errorf("GET /api/dead = %d %+v", rec.Code, files)
+	}
+
+	f.queue.err = errors.New("database is closed")
+
+	for _, target := range []string{"/api/queue", "/api/dead"} {
+		if rec := request(t, handler, http.MethodGet, target); rec.Code != http.StatusInternalServerError {
+			t.Errorf("GET %s with queue error code = %d, want 500", target, rec.Code)
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	f := newFixture(0)
+	handler := f.server.Handler()
+
+	if rec := request(t, handler, http.MethodPost, "/api/pause"); rec.Code != http.StatusNoContent || !f.engine.paused {
+		t.Errorf("POST /api/pause = %d, paused %v", rec.Code, f.engine.paused)
+	}
+
+	if report := decode[engine.Report](t, request(t, handler, http.MethodGet, "/api/status")); !report.Paused {
+		t.Errorf("status after pause = %+v, want paused", report)
+	}
+
+	if rec := request(t, handler, http.MethodPost, "/api/resume"); rec.Code != http.StatusNoContent || f.engine.paused {
+		t.Errorf("POST /api/resume = %d, paused %v", rec.Code, f.engine.paused)
+	}
+
+	if rec := request(t, handler, http.MethodPost, "/api/scan"); rec.Code != http.StatusAccepted || f.engine.rescans != 1 {
+		t.Errorf("POST /api/scan = %d, rescans %d", rec.Code, f.engine.rescans)
+	}
+
+	// Управление доступно только методом POST
+	if rec := request(t, handler, http.MethodGet, "/api/pause"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/pause code = %d, want 405", rec.Code)
+	}
+}
+
+func TestQueueActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   int
+	}{
+		{"retry", http.MethodPost, "/api/queue/a/retry", http.StatusNoContent},
+		{"retry missing", http.MethodPost, "/api/queue/x/retry", http.StatusNotFound},
+		{"retry active", http.MethodPost, "/api/queue/busy/retry", http.StatusConflict},
+		{"priority", http.MethodPost, "/api/queue/b/priority?value=-5", http.StatusNoContent},
+		{"priority without value", http.MethodPost, "/api/queue/b/priority", http.StatusBadRequest},
+		{"priority invalid", http.MethodPost, "/api/queue/b/priority?value=high", http.StatusBadRequest},
+		{"priority missing", http.MethodPost, "/api/queue/x/priority?value=1", http.StatusNotFound},
+		{"delete", http.MethodDelete, "/api/queue/a", http.StatusNoContent},
+		{"delete missing", http.MethodDelete, "/api/queue/x", http.StatusNotFound},
+		{"delete active", http.MethodDelete, "/api/queue/busy", http.StatusConflict},
+	}
+
+	f := newFixture(2)
+	handler := f.server.Handler()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(t, handler, tt.method, tt.target)
+			if rec.Code != tt.code {
+				t.Errorf("%s %s code = %d, want %d (%s)", tt.method, tt.target, rec.Code, tt.code, rec.Body.String())
+			}
+
+			if rec.Code >= 400 {
+				if body := decode[map[string]string](t, rec); body["error"] == "" {
+					t.Errorf("%s %s returned no error message", tt.method, tt.target)
+				}
+			}
+		})
+	}
+
+	if len(f.queue.retried) != 1 || f.queue.retried[0] != "a" {
+		t.Errorf("retried = %v, want [a]", f.queue.retried)
+	}
+
+	if len(f.queue.priorities) != 1 || f.queue.priorities["b"] != -5 {
+		t.Errorf("priorities = %v, want b: -5", f.queue.priorities)
+	}
+
+	if len(f.queue.deleted) != 1 || f.queue.deleted[0] != "a" {
+		t.Errorf("deleted = %v, want [a]", f.queue.deleted)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	f := newFixture(0)
+	handler := f.server.Handler()
+
+	rates := func(rec *httptest.ResponseRecorder) map[string]int64 {
+		t.Helper()
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("limit code = %d, want 200 (%s)", rec.Code, rec.Body.String())
+		}
+
+		return decode[map[string]int64](t, rec)
+	}
+
+	if got := rates(request(t, handler, http.MethodGet, "/api/limit")); got["Global"] != 1<<20 || got["PerFile"] != 0 {
+		t.Errorf("GET /api/limit = %v", got)
+	}
+
+	got := rates(request(t, handler, http.MethodPost, "/api/limit?rate=2M&file=512K"))
+	if got["Global"] != 2<<20 || got["PerFile"] != 512<<10 {
+		t.Errorf("POST /api/limit = %v, want 2M and 512K", got)
+	}
+
+	// Изменение одной скорости сохраняет другую
+	got = rates(request(t, handler, http.MethodPost, "/api/limit?file=unlimited"))
+	if got["Global"] != 2<<20 || got["PerFile"] != 0 {
+		t.Errorf("POST /api/limit?file=unlimited = %v, want 2M and no per file limit", got)
+	}
+
+	// Неверное значение не применяет ни одну из скоростей
+	rec := request(t, handler, http.MethodPost, "/api/limit?rate=1M&file=fast")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/limit with invalid rate code = %d, want 400", rec.Code)
+	}
+
+	if f.bandwidth.global != 2<<20 || f.bandwidth.perFile != 0 {
+		t.Errorf("rates after invalid request = %d, %d; want unchanged", f.bandwidth.global, f.bandwidth.perFile)
+	}
+
+	if got := rates(request(t, handler, http.MethodDelete, "/api/limit")); got["Global"] != 1<<20 {
+		t.Errorf("DELETE /api/limit = %v, want scheduled rate", got)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	first, second := newFixture(1), newFixture(2)
+
+	handler := server.Jobs(map[string]*server.Server{
+		"movies": first.server,
+		"photos": second.server,
+	})
+
+	rec := request(t, handler, http.MethodPost, "/jobs/movies/api/pause")
+	if rec.Code != http.StatusNoContent || !first.engine.paused || second.engine.paused {
+		t.Errorf("POST /jobs/movies/api/pause = %d, paused %v %v; want only movies paused",
+			rec.Code, first.engine.paused, second.engine.paused)
+	}
+
+	report := decode[engine.Report](t, request(t, handler, http.MethodGet, "/jobs/photos/api/status"))
+	if report.Queue.Files != 2 || report.Paused {
+		t.Errorf("GET /jobs/photos/api/status = %+v, want photos report", report)
+	}
+
+	rec = request(t, handler, http.MethodDelete, "/jobs/photos/api/queue/b")
+	if rec.Code != http.StatusNoContent || len(second.queue.deleted) != 1 || len(first.queue.deleted) != 0 {
+		t.Errorf("DELETE /jobs/photos/api/queue/b = %d, deleted %v %v", rec.Code, first.queue.deleted, second.queue.deleted)
+	}
+
+	// Метрики процесса выдаются один раз, очереди заданий суммируются
+	rec = request(t, handler, http.MethodGet, "/metrics")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "\nwddl_queue_files 3\n") {
+		t.Errorf("GET /metrics = %d %q, want queues of both jobs", rec.Code, rec.Body.String())
+	}
+
+	for _, target := range []string{"/jobs/music/api/status", "/api/status", "/jobs/movies/metrics"} {
+		if rec := request(t, handler, http.MethodGet, target); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s code = %d, want 404", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+func init() {
+	Register(openFile, "file")
+}
+
+// openFile - открывает локальную директорию file:///path,
+// например смонтированный сетевой ресурс SMB или NFS
+func openFile(u *url.URL, _ Options) (Source, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("file: remote host %s is not supported, mount the share locally", u.Host)
+	}
+
+	info, err := os.Stat(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("file: %w", err)
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("file: %s is not a directory", u.Path)
+	}
+
+	return NewLocal(u.Path), nil
+}
+
+// NewLocal - создает источник из локальной директории root
+func NewLocal(root string) *Local {
+	return &Local{
+		root: root,
+	}
+}
+
+// Local - источник в локальной файловой системе
+type Local struct {
+	root string
+}
+
+// ReadDir - возвращает файлы и директории, символьные ссылки
+// и специальные файлы пропускаются
+func (l *Local) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(l.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// Файл удален во время чтения директории
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+func (l *Local) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(l.path(name))
+}
+
+func (l *Local) ReadStreamRange(name string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &readCloser{Reader: limit(f, length), close: f.Close}, nil
+}
+
+func (l *Local) Remove(name string) error {
+	return os.Remove(l.path(name))
+}
+
+// path - путь файла внутри корневой директории, выход за ее пределы невозможен
+func (l *Local) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+name)))
+}
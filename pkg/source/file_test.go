@@ -0,0 +1,104 @@
+package source_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/files"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/ReanSn0w/wddl/pkg/source"
+	"github.com/go-pkgz/lgr"
+)
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(dir+"/share/sub", 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	if err := os.WriteFile(dir+"/share/sub/a.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	src, err := source.Open("file://"+dir+"/share", source.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	testSource(t, src, "/sub/a.txt")
+
+	if _, err := source.Open("file://"+dir+"/missing", source.Options{}); err == nil {
+		t.Errorf("Open() of missing directory should fail")
+	}
+
+	if _, err := src.Stat("/../share"); !os.IsNotExist(err) {
+		t.Errorf("Stat() outside of root error = %v, want not exist", err)
+	}
+}
+
+func TestLocalEngine(t *testing.T) {
+	dir := t.TempDir()
+	share := map[string][]byte{
+		"a.txt":       []byte("a"),
+		"dir/big.bin": bytes.Repeat([]byte("0123456789"), 1000),
+	}
+
+	for name, data := range share {
+		name = filepath.Join(dir, "share", name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	q, err := queue.New(dir + "/wddl.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	conf := engine.Config{
+		InputPath:        "/",
+		OutputPath:       dir + "/output",
+		TempPath:         dir + "/temp",
+		Concurrency:      2,
+		PartitionThreads: 2,
+		PartitionSize:    1024,
+		ScanEvery:        time.Hour,
+		RemoveRemote:     true,
+		MaxFailures:      1,
+		HistoryMode:      engine.HistoryModeMirror,
+	}
+
+	fs := files.New(source.NewLocal(dir + "/share"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	summary, err := engine.New(lgr.New(), conf, fs, fs, q, q).Once(ctx)
+	if err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if summary.Files != 2 || summary.Failed != 0 {
+		t.Errorf("Once() summary = %s", summary)
+	}
+
+	for name, expected := range share {
+		data, err := os.ReadFile(filepath.Join(conf.OutputPath, name))
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("downloaded %s mismatch: %v", name, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "share", name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed from share, stat error = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package source_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ReanSn0w/wddl/pkg/source"
+)
+
+func TestFTP(t *testing.T) {
+	srv := newFTPServer(t, map[string]string{"/data/sub/a.txt": "0123456789"})
+
+	src, err := source.Open("ftp://user:secret@"+srv.addr+"/data", source.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	testSource(t, src, "/sub/a.txt")
+}
+
+// ftpServer - FTP сервер в памяти, поддерживающий команды,
+// которые использует источник, и пассивный режим EPSV
+type ftpServer struct {
+	addr string
+
+	mx    sync.Mutex
+	files map[string]string
+}
+
+func newFTPServer(t *testing.T, files map[string]string) *ftpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &ftpServer{addr: ln.Addr().String(), files: files}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *ftpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var (
+		data   net.Listener
+		offset int
+	)
+
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+
+	reply("220 ready")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			reply("230 logged in")
+		case "TYPE", "NOOP":
+			reply("200 ok")
+		case "EPSV":
+			if data != nil {
+				data.Close()
+			}
+
+			data, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 can not open data connection")
+				continue
+			}
+
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			offset, _ = strconv.Atoi(arg)
+			reply("350 restarting")
+		case "LIST":
+			s.transfer(data, reply, s.list(arg))
+		case "RETR":
+			s.mx.Lock()
+			content, ok := s.files[arg]
+			s.mx.Unlock()
+
+			if !ok || offset > len(content) {
+				reply("550 not found")
+				continue
+			}
+
+			s.transfer(data, reply, content[offset:])
+			offset = 0
+		case "DELE":
+			s.mx.Lock()
+			_, ok := s.files[arg]
+			delete(s.files, arg)
+			s.mx.Unlock()
+
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+
+			reply("250 deleted")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// transfer - передает content через пассивное соединение
+func (s *ftpServer) transfer(data net.Listener, reply func(string, ...any), content string) {
+	if data == nil {
+		reply("425 use EPSV first")
+		return
+	}
+
+	reply("150 opening data connection")
+
+	conn, err := data.Accept()
+	if err != nil {
+		reply("425 can not open data connection")
+		return
+	}
+
+	// Клиент может закрыть соединение, не дочитав файл
+	conn.Write([]byte(content))
+	conn.Close()
+
+	reply("226 transfer complete")
+}
+
+// list - возвращает содержимое директории в формате ls -l
+func (s *ftpServer) list(dir string) string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	entries := make(map[string]string)
+
+	for name, content := range s.files {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+
+		if sub, _, isDir := strings.Cut(rest, "/"); isDir {
+			entries[sub] = "drwxr-xr-x 1 ftp ftp 0 Jan 01 2024 " + sub
+		} else {
+			entries[rest] = fmt.Sprintf("-rw-r--r-- 1 ftp ftp %d Jan 01 2024 %s", len(content), path.Base(name))
+		}
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, line := range entries {
+		lines = append(lines, line+"\r\n")
+	}
+
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,179 @@
+package source
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func init() {
+	Register(openS3, "s3")
+}
+
+// openS3 - подключается к S3 совместимому хранилищу s3://bucket/prefix
+//
+// Параметры адреса: endpoint - адрес хранилища (по умолчанию s3.amazonaws.com),
+// region, insecure=true - подключение по HTTP. Ключ доступа и секрет задаются
+// пользователем и паролем в адресе, иначе берутся из переменных окружения AWS_*
+func openS3(u *url.URL, opts Options) (Source, error) {
+	query := u.Query()
+
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	creds := credentials.NewEnvAWS()
+	if u.User != nil {
+		user, password := userinfo(u, opts)
+		creds = credentials.NewStaticV4(user, password, "")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: query.Get("insecure") != "true",
+		Region: query.Get("region"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("s3: bucket %s does not exist", u.Host)
+	}
+
+	return NewS3(client, u.Host, u.Path), nil
+}
+
+// NewS3 - создает источник из объектов корзины bucket с префиксом prefix
+//
+// Директории эмулируются по разделителю "/" в именах объектов
+func NewS3(client *minio.Client, bucket, prefix string) *S3 {
+	return &S3{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+// S3 - источник в S3 совместимом хранилище
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s *S3) ReadDir(dir string) ([]os.FileInfo, error) {
+	prefix := s.key(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var result []os.FileInfo
+	for object := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		name := strings.TrimPrefix(object.Key, prefix)
+		if name == "" {
+			// Объект-маркер самой директории
+			continue
+		}
+
+		if strings.HasSuffix(name, "/") {
+			result = append(result, &fileInfo{name: strings.TrimSuffix(name, "/"), isDir: true})
+			continue
+		}
+
+		result = append(result, s3Info(name, object))
+	}
+
+	return result, nil
+}
+
+func (s *S3) Stat(name string) (os.FileInfo, error) {
+	key := s.key(name)
+	if key == "" {
+		return &fileInfo{name: "/", isDir: true}, nil
+	}
+
+	object, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return s3Info(path.Base(key), object), nil
+	}
+
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+
+	// Директория существует, если существует хотя бы один объект с ее префиксом
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: key + "/", MaxKeys: 1}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		return &fileInfo{name: path.Base(key), isDir: true}, nil
+	}
+
+	return nil, notExist("stat", name)
+}
+
+func (s *S3) ReadStreamRange(name string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.client.GetObject(context.Background(), s.bucket, s.key(name), opts)
+}
+
+func (s *S3) Remove(name string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.key(name), minio.RemoveObjectOptions{})
+}
+
+func (s *S3) key(name string) string {
+	return strings.Trim(path.Join(s.prefix, name), "/")
+}
+
+// s3Info - преобразует свойства объекта, ETag объекта, загруженного
+// одним запросом, является md5 суммой его содержимого
+func s3Info(name string, object minio.ObjectInfo) *fileInfo {
+	info := &fileInfo{
+		name:    name,
+		size:    object.Size,
+		modTime: object.LastModified,
+	}
+
+	etag := strings.ToLower(strings.Trim(object.ETag, `"`))
+	if sum, err := hex.DecodeString(etag); err == nil && len(sum) == 16 {
+		info.checksum = "md5:" + etag
+	}
+
+	return info
+}
@@ -0,0 +1,170 @@
+package source_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/source"
+)
+
+func TestS3(t *testing.T) {
+	srv := newS3Server("bucket", map[string]string{"data/sub/a.txt": "0123456789"})
+	defer srv.Close()
+
+	address := "s3://key:secret@bucket/data?insecure=true&region=us-east-1&endpoint=" + strings.TrimPrefix(srv.URL, "http://")
+
+	src, err := source.Open(address, source.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	info, err := src.Stat("/sub/a.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	// ETag объекта, загруженного одним запросом, - md5 содержимого
+	sum := md5.Sum([]byte("0123456789"))
+	if c, ok := info.(interface{ Checksum() string }); !ok || c.Checksum() != "md5:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("Stat() checksum is missing or wrong")
+	}
+
+	if info, err := src.Stat("/sub"); err != nil || !info.IsDir() {
+		t.Errorf("Stat() of prefix = %v, %v, want directory", info, err)
+	}
+
+	testSource(t, src, "/sub/a.txt")
+}
+
+// s3Server - S3 хранилище в памяти с одной корзиной,
+// поддерживающее запросы, которые использует источник
+type s3Server struct {
+	*httptest.Server
+
+	bucket   string
+	modified time.Time
+
+	mx      sync.Mutex
+	objects map[string]string
+}
+
+func newS3Server(bucket string, objects map[string]string) *s3Server {
+	s := &s3Server{
+		bucket:   bucket,
+		modified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		objects:  objects,
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *s3Server) handle(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.mx.Lock()
+		content, ok := s.objects[key]
+		s.mx.Unlock()
+
+		if !ok {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+
+		sum := md5.Sum([]byte(content))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		http.ServeContent(w, r, key, s.modified, strings.NewReader(content))
+	case r.Method == http.MethodDelete:
+		s.mx.Lock()
+		delete(s.objects, key)
+		s.mx.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "", http.StatusNotImplemented)
+	}
+}
+
+// list - отвечает на запрос ListObjectsV2
+func (s *s3Server) list(w http.ResponseWriter, prefix, delimiter string) {
+	type object struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+	}
+
+	type commonPrefix struct {
+		Prefix string
+	}
+
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		IsTruncated    bool
+		Contents       []object
+		CommonPrefixes []commonPrefix
+	}{Name: s.bucket, Prefix: prefix}
+
+	s.mx.Lock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	prefixes := make(map[string]bool)
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+
+		if dir, _, found := strings.Cut(rest, delimiter); delimiter != "" && found {
+			if !prefixes[dir] {
+				prefixes[dir] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: prefix + dir + delimiter})
+			}
+
+			continue
+		}
+
+		sum := md5.Sum([]byte(s.objects[key]))
+		result.Contents = append(result.Contents, object{
+			Key:          key,
+			Size:         len(s.objects[key]),
+			LastModified: s.modified.Format(time.RFC3339),
+			ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		})
+	}
+	s.mx.Unlock()
+
+	var buf bytes.Buffer
+	if err := xml.NewEncoder(&buf).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf.Bytes())
+}
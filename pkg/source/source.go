@@ -0,0 +1,148 @@
+// Package source подключает хранилища, из которых загружаются файлы,
+// реализация выбирается по схеме адреса источника
+package source
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source - хранилище, из которого загружаются файлы
+//
+// Пути передаются относительно корня, заданного в адресе источника.
+// ReadStreamRange с length <= 0 возвращает данные от offset до конца файла
+type Source interface {
+	ReadDir(path string) ([]os.FileInfo, error)
+	ReadStreamRange(path string, offset int64, length int64) (io.ReadCloser, error)
+	Remove(path string) error
+	Stat(path string) (os.FileInfo, error)
+}
+
+// Options - параметры подключения, не заданные в адресе источника
+type Options struct {
+	User     string
+	Password string
+
+	// Считать md5-подобный ETag контрольной суммой (WebDAV Яндекс Диска)
+	ETagMD5 bool
+}
+
+// Opener - подключается к источнику по разобранному адресу
+type Opener func(u *url.URL, opts Options) (Source, error)
+
+var (
+	mx      sync.RWMutex
+	openers = make(map[string]Opener)
+)
+
+// Register - регистрирует реализацию источника для схем адреса
+func Register(opener Opener, schemes ...string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	for _, scheme := range schemes {
+		openers[scheme] = opener
+	}
+}
+
+// Schemes - возвращает зарегистрированные схемы адресов
+func Schemes() []string {
+	mx.RLock()
+	defer mx.RUnlock()
+
+	result := make([]string, 0, len(openers))
+	for scheme := range openers {
+		result = append(result, scheme)
+	}
+
+	slices.Sort(result)
+	return result
+}
+
+// Open - подключается к источнику по адресу вида scheme://[user[:password]@]host/root
+func Open(address string, opts Options) (Source, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	mx.RLock()
+	opener, ok := openers[strings.ToLower(u.Scheme)]
+	mx.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported source scheme %q, supported: %s", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	return opener(u, opts)
+}
+
+// userinfo - возвращает имя пользователя и пароль из адреса,
+// если они в нем не заданы - из параметров подключения
+func userinfo(u *url.URL, opts Options) (string, string) {
+	if u.User == nil {
+		return opts.User, opts.Password
+	}
+
+	password, _ := u.User.Password()
+	return u.User.Username(), password
+}
+
+// notExist - ошибка отсутствия файла, соответствующая os.ErrNotExist
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// fileInfo - свойства файла источника
+type fileInfo struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	isDir    bool
+	checksum string
+}
+
+func (f *fileInfo) Name() string       { return f.name }
+func (f *fileInfo) Size() int64        { return f.size }
+func (f *fileInfo) ModTime() time.Time { return f.modTime }
+func (f *fileInfo) IsDir() bool        { return f.isDir }
+func (f *fileInfo) Sys() any           { return nil }
+
+func (f *fileInfo) Mode() os.FileMode {
+	if f.isDir {
+		return 0775 | os.ModeDir
+	}
+
+	return 0664
+}
+
+// Checksum - контрольная сумма файла в формате "алгоритм:значение"
+// или пустая строка, если хранилище ее не предоставляет
+func (f *fileInfo) Checksum() string {
+	return f.checksum
+}
+
+// limit - ограничивает поток length байтами, length <= 0 - до конца файла
+func limit(r io.Reader, length int64) io.Reader {
+	if length <= 0 {
+		return r
+	}
+
+	return io.LimitReader(r, length)
+}
+
+// readCloser - поток части файла, закрывающий источник данных
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
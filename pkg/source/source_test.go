@@ -0,0 +1,219 @@
+package source_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ReanSn0w/wddl/pkg/davtest"
+	"github.com/ReanSn0w/wddl/pkg/source"
+	"github.com/pkg/sftp"
+)
+
+func TestOpenUnknownScheme(t *testing.T) {
+	_, err := source.Open("gopher://example.com/files", source.Options{})
+	if err == nil || !strings.Contains(err.Error(), "gopher") {
+		t.Errorf("Open() error = %v, want unsupported scheme", err)
+	}
+
+	for _, scheme := range []string{"webdav", "davs", "sftp", "s3", "ftp", "file"} {
+		if !strings.Contains(strings.Join(source.Schemes(), " "), scheme) {
+			t.Errorf("scheme %s is not registered", scheme)
+		}
+	}
+}
+
+func TestWebdavURL(t *testing.T) {
+	cases := map[string]string{
+		"webdav://host/dav":        "http://host/dav",
+		"davs://user@host:8443/":   "https://user@host:8443/",
+		"https://host/remote.php/": "https://host/remote.php/",
+	}
+
+	for address, expected := range cases {
+		got, ok := source.WebdavURL(address)
+		if !ok || got != expected {
+			t.Errorf("WebdavURL(%s) = %s, %v, want %s", address, got, ok, expected)
+		}
+	}
+
+	if _, ok := source.WebdavURL("sftp://host/data"); ok {
+		t.Errorf("WebdavURL() should reject sftp://")
+	}
+}
+
+func TestWebdav(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	if err := srv.WriteFile("/data/a.txt", []byte("0123456789")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	src, err := source.Open(strings.Replace(srv.URL, "http://", "webdav://", 1)+"/data", source.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	testSource(t, src, "/a.txt")
+}
+
+func TestSFTP(t *testing.T) {
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverRead, serverWrite}, sftp.InMemHandler())
+	go server.Serve()
+	defer server.Close()
+
+	client, err := sftp.NewClientPipe(clientRead, clientWrite)
+	if err != nil {
+		t.Fatalf("NewClientPipe() error = %v", err)
+	}
+
+	if err := client.MkdirAll("/data/sub"); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	f, err := client.Create("/data/sub/a.txt")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	dials := 0
+	src := source.NewSFTP(func() (*sftp.Client, error) {
+		dials++
+		return client, nil
+	}, "/data")
+
+	testSource(t, src, "/sub/a.txt")
+
+	if dials != 1 {
+		t.Errorf("connection should be reused, dialed %d times", dials)
+	}
+}
+
+func TestSFTPRedial(t *testing.T) {
+	handlers := sftp.InMemHandler()
+
+	// connect - подключается к серверу с общей файловой системой,
+	// close разрывает соединение со стороны сервера
+	connect := func() (*sftp.Client, func()) {
+		clientRead, serverWrite := io.Pipe()
+		serverRead, clientWrite := io.Pipe()
+
+		server := sftp.NewRequestServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{serverRead, serverWrite}, handlers)
+		go server.Serve()
+		t.Cleanup(func() { server.Close() })
+
+		client, err := sftp.NewClientPipe(clientRead, clientWrite)
+		if err != nil {
+			t.Fatalf("NewClientPipe() error = %v", err)
+		}
+
+		return client, func() {
+			serverWrite.CloseWithError(errors.New("connection reset"))
+			serverRead.CloseWithError(errors.New("connection reset"))
+		}
+	}
+
+	client, drop := connect()
+
+	f, err := client.Create("/a.txt")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	dials := 0
+	src := source.NewSFTP(func() (*sftp.Client, error) {
+		dials++
+		if dials == 1 {
+			return client, nil
+		}
+
+		client, _ := connect()
+		return client, nil
+	}, "/")
+
+	stream, err := src.ReadStreamRange("/a.txt", 0, 0)
+	if err != nil {
+		t.Fatalf("ReadStreamRange() error = %v", err)
+	}
+	defer stream.Close()
+
+	drop()
+
+	if _, err := io.ReadAll(stream); err == nil {
+		t.Fatalf("ReadAll() after connection loss should fail")
+	}
+
+	// Ошибка чтения потока сбрасывает соединение
+	if info, err := src.Stat("/a.txt"); err != nil || info.Size() != 10 {
+		t.Fatalf("Stat() after redial = %v, %v", info, err)
+	}
+
+	if dials != 2 {
+		t.Errorf("connection should be redialed after read error, dialed %d times", dials)
+	}
+}
+
+// testSource - проверяет чтение части, свойства и удаление файла с содержимым "0123456789"
+func testSource(t *testing.T, src source.Source, name string) {
+	t.Helper()
+
+	info, err := src.Stat(name)
+	if err != nil || info.Size() != 10 || info.IsDir() {
+		t.Fatalf("Stat() = %v, %v", info, err)
+	}
+
+	stream, err := src.ReadStreamRange(name, 3, 4)
+	if err != nil {
+		t.Fatalf("ReadStreamRange() error = %v", err)
+	}
+
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil || string(data) != "3456" {
+		t.Errorf("ReadStreamRange() = %q, %v, want \"3456\"", data, err)
+	}
+
+	// Без длины поток читается до конца файла
+	stream, err = src.ReadStreamRange(name, 7, 0)
+	if err != nil {
+		t.Fatalf("ReadStreamRange() to end error = %v", err)
+	}
+
+	data, err = io.ReadAll(stream)
+	stream.Close()
+	if err != nil || string(data) != "789" {
+		t.Errorf("ReadStreamRange() to end = %q, %v, want \"789\"", data, err)
+	}
+
+	parent := name[:strings.LastIndex(name, "/")+1]
+	files, err := src.ReadDir(parent)
+	if err != nil || len(files) != 1 || files[0].Name() != "a.txt" {
+		t.Errorf("ReadDir(%s) = %v, %v", parent, files, err)
+	}
+
+	if err := src.Remove(name); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	if _, err := src.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() after Remove() error = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,72 @@
+package source
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ReanSn0w/wddl/pkg/dav"
+	"github.com/studio-b12/gowebdav"
+)
+
+func init() {
+	Register(openWebdav, "http", "https", "webdav", "davs")
+}
+
+// WebdavURL - возвращает HTTP адрес WebDAV сервера для адреса источника
+//
+// Схемы webdav:// и davs:// заменяются на http:// и https://,
+// для источников других типов возвращает false
+func WebdavURL(address string) (string, bool) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "webdav":
+		u.Scheme = "http"
+	case "davs":
+		u.Scheme = "https"
+	default:
+		return "", false
+	}
+
+	return u.String(), true
+}
+
+// openWebdav - подключается к WebDAV серверу
+func openWebdav(u *url.URL, opts Options) (Source, error) {
+	user, password := userinfo(u, opts)
+
+	server := *u
+	server.User = nil
+
+	address, _ := WebdavURL(server.String())
+
+	client := dav.New(address, gowebdav.NewAutoAuth(user, password), opts.ETagMD5)
+	err := client.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("webdav: %w", err)
+	}
+
+	return &webdav{client}, nil
+}
+
+// webdav - источник на WebDAV сервере
+type webdav struct {
+	*dav.Client
+}
+
+// Stat - сообщает об отсутствии файла ошибкой os.ErrNotExist,
+// как и остальные источники
+func (w *webdav) Stat(name string) (os.FileInfo, error) {
+	info, err := w.Client.Stat(name)
+	if gowebdav.IsErrNotFound(err) {
+		return nil, notExist("stat", name)
+	}
+
+	return info, err
+}
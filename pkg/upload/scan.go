@@ -0,0 +1,77 @@
+package upload
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/go-pkgz/lgr"
+)
+
+// Scan - возвращает файлы локальной директории inputDir
+//
+// Путь в удаленном хранилище строится от OutputPath по пути файла
+// относительно InputPath, символьные ссылки и специальные файлы пропускаются
+func (u *Uploader) Scan(ctx context.Context, conf engine.Config, inputDir string) ([]engine.File, error) {
+	var result []engine.File
+
+	err := filepath.WalkDir(inputDir, func(source string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if source == inputDir {
+			return nil
+		}
+
+		relative := filepath.ToSlash(strings.TrimPrefix(source, conf.InputPath))
+
+		if entry.IsDir() {
+			if conf.Filter != nil && conf.Filter.SkipDir(relative) {
+				lgr.Default().Logf("[DEBUG] directory %s skipped by filter", relative)
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// Файл удален во время сканирования
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if conf.Filter != nil && conf.Filter.Skip(relative, info.Size()) {
+			lgr.Default().Logf("[DEBUG] file %s skipped by filter", relative)
+			return nil
+		}
+
+		item := engine.NewFile(conf, source, info.Size())
+		item.Dest = conf.OutputPath + relative
+		item.ModTime = info.ModTime()
+
+		result = append(result, item)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,445 @@
+// Package upload реализует выгрузку локальных файлов в удаленное
+// хранилище через очередь и воркеры движка
+package upload
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/metrics"
+	"github.com/ReanSn0w/wddl/pkg/retry"
+	"github.com/go-pkgz/lgr"
+	"github.com/studio-b12/gowebdav"
+)
+
+type Webdav interface {
+	Stat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+	MkdirAll(path string, mode os.FileMode) error
+	Remove(path string) error
+	Rename(oldpath, newpath string, overwrite bool) error
+	Do(ctx context.Context, method, path string, body io.Reader, size int64, header http.Header) (*http.Response, error)
+	URL(path string) string
+}
+
+// New - создает выгрузчик файлов
+//
+// uploads - клиент для каталога загрузок Nextcloud (remote.php/dav/uploads/<user>),
+// nil - файлы выгружаются одним запросом PUT
+func New(client Webdav, uploads Webdav) *Uploader {
+	return &Uploader{
+		client:  client,
+		uploads: uploads,
+	}
+}
+
+// Uploader - выгружает локальные файлы в удаленное хранилище
+//
+// Реализует engine.Scanner, engine.Downloader и engine.Target, поэтому
+// выгрузка использует очередь, воркеры и повторные попытки движка:
+// Source файла - локальный путь, Dest - путь в удаленном хранилище
+type Uploader struct {
+	client  Webdav
+	uploads Webdav
+}
+
+// NextcloudUploads - возвращает адрес каталога загрузок частями
+// для адреса WebDAV сервера Nextcloud вида .../remote.php/dav/files/<user>
+func NextcloudUploads(server string) (string, bool) {
+	const files = "/remote.php/dav/files/"
+
+	before, user, ok := strings.Cut(server, files)
+	user = strings.Trim(user, "/")
+	if !ok || user == "" {
+		return "", false
+	}
+
+	return before + "/remote.php/dav/uploads/" + user, true
+}
+
+// Stat - возвращает информацию о файле в удаленном хранилище
+func (u *Uploader) Stat(name string) (os.FileInfo, error) {
+	info, err := u.client.Stat(name)
+	if gowebdav.IsErrNotFound(err) {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+
+	return info, err
+}
+
+// Remove - удаляет файл в удаленном хранилище
+func (u *Uploader) Remove(name string) error {
+	return u.client.Remove(name)
+}
+
+// Rename - переносит файл в удаленном хранилище,
+// существующий файл по новому пути не перезаписывается
+func (u *Uploader) Rename(oldname, newname string) error {
+	err := u.client.MkdirAll(path.Dir(newname), 0755)
+	if err != nil {
+		return err
+	}
+
+	return u.client.Rename(oldname, newname, false)
+}
+
+// Download - выгружает локальный файл и возвращает его контрольную сумму (sha256)
+//
+// Файл больше одной части выгружается частями, если задан каталог загрузок
+// Nextcloud. Выгрузка считается завершенной после проверки размера
+// (и контрольной суммы, если сервер ее предоставляет) файла на сервере
+func (u *Uploader) Download(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File) (string, error) {
+	local, err := os.Open(file.Source)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file: %w", err)
+	}
+
+	defer local.Close()
+
+	info, err := local.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat local file: %w", err)
+	}
+
+	if info.Size() != file.Size {
+		return "", fmt.Errorf("local file changed after scan: expected %d bytes, got %d", file.Size, info.Size())
+	}
+
+	sums, err := checksums(local)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash local file: %w", err)
+	}
+
+	err = u.client.MkdirAll(path.Dir(file.Dest), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("OC-Checksum", "SHA1:"+sums["sha1"])
+	if !file.ModTime.IsZero() {
+		header.Set("X-OC-Mtime", strconv.FormatInt(file.ModTime.Unix(), 10))
+	}
+
+	layout := newLayout(file)
+	if u.uploads != nil && layout.count > 1 {
+		lgr.Default().Logf("[DEBUG] uploading file %s in %d chunks", file.Name, layout.count)
+		err = u.uploadChunks(ctx, conf, pch, file, local, layout, header)
+	} else {
+		lgr.Default().Logf("[DEBUG] uploading file %s", file.Name)
+		err = u.put(ctx, conf, pch, file, local, header)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	err = u.verify(conf, file, sums)
+	if err != nil {
+		return "", err
+	}
+
+	return sums["sha256"], nil
+}
+
+// Delete - удаляет локальный файл после выгрузки
+func (u *Uploader) Delete(file engine.File) error {
+	return os.Remove(file.Source)
+}
+
+// put - выгружает файл одним запросом
+func (u *Uploader) put(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File, local *os.File, header http.Header) error {
+	if conf.Interrupted(time.Now()) {
+		return engine.ErrPaused
+	}
+
+	started := time.Now()
+	body := limiter(ctx, conf)(io.NewSectionReader(local, 0, file.Size))
+
+	resp, err := u.client.Do(ctx, http.MethodPut, file.Dest, body, file.Size, header)
+	err = expect(resp, err, "Put", file.Dest, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	metrics.UploadedBytes.Add(file.Size)
+	sendProgress(ctx, pch, makeProgress(file, 1, 1, file.Size, time.Since(started)))
+	return nil
+}
+
+// uploadChunks - выгружает файл частями по протоколу Nextcloud chunking v2
+//
+// Части хранятся на сервере в директории загрузки, имя которой зависит
+// от идентификатора файла, поэтому после сбоя или перезапуска
+// выгружаются только отсутствующие части
+func (u *Uploader) uploadChunks(ctx context.Context, conf engine.Config, pch chan<- engine.Progress, file engine.File, local *os.File, layout layout, header http.Header) error {
+	dir := "/wddl-" + file.ID
+
+	chunkHeader := http.Header{}
+	chunkHeader.Set("Destination", u.client.URL(file.Dest))
+	chunkHeader.Set("OC-Total-Length", strconv.FormatInt(file.Size, 10))
+
+	// 405 - директория загрузки создана предыдущей попыткой
+	resp, err := u.uploads.Do(ctx, "MKCOL", dir, nil, -1, chunkHeader)
+	err = expect(resp, err, "Mkcol", dir, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	uploaded, err := u.uploadedChunks(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list uploaded chunks: %w", err)
+	}
+
+	var (
+		limit   = limiter(ctx, conf)
+		started = time.Now()
+		done    int64
+		sent    int64
+	)
+
+	for index := int64(1); index <= layout.count; index++ {
+		size := layout.sizeOf(index)
+		name := path.Join(dir, chunkName(index))
+
+		if uploaded[chunkName(index)] == size {
+			done++
+			continue
+		}
+
+		// Выгруженные части сохраняются до следующего окна
+		if conf.Interrupted(time.Now()) {
+			return engine.ErrPaused
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		lgr.Default().Logf("[DEBUG] uploading chunk %d/%d of file %s", index, layout.count, file.Name)
+		body := limit(io.NewSectionReader(local, layout.offsetOf(index), size))
+
+		resp, err := u.uploads.Do(ctx, http.MethodPut, name, body, size, chunkHeader)
+		err = expect(resp, err, "Put", name, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+		if err != nil {
+			return fmt.Errorf("failed to upload chunk %d: %w", index, err)
+		}
+
+		metrics.UploadedBytes.Add(size)
+		done++
+		sent += size
+
+		sendProgress(ctx, pch, makeProgress(file, done, layout.count, sent, time.Since(started)))
+	}
+
+	moveHeader := chunkHeader.Clone()
+	for key, values := range header {
+		moveHeader[key] = values
+	}
+
+	name := path.Join(dir, ".file")
+	resp, err = u.uploads.Do(ctx, "MOVE", name, nil, -1, moveHeader)
+	err = expect(resp, err, "Move", name, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to assemble chunks: %w", err)
+	}
+
+	return nil
+}
+
+// uploadedChunks - возвращает размеры частей, уже выгруженных на сервер
+func (u *Uploader) uploadedChunks(dir string) (map[string]int64, error) {
+	items, err := u.uploads.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]int64, len(items))
+	for _, item := range items {
+		result[item.Name()] = item.Size()
+	}
+
+	return result, nil
+}
+
+// verify - проверяет размер выгруженного файла и его контрольную
+// сумму, если она включена и сервер ее предоставляет
+func (u *Uploader) verify(conf engine.Config, file engine.File, sums map[string]string) error {
+	info, err := u.client.Stat(file.Dest)
+	if err != nil {
+		return fmt.Errorf("failed to stat uploaded file: %w", err)
+	}
+
+	if info.Size() != file.Size {
+		return fmt.Errorf("uploaded file size mismatch: expected %d, got %d", file.Size, info.Size())
+	}
+
+	if !conf.VerifyChecksum {
+		return nil
+	}
+
+	c, ok := info.(interface{ Checksum() string })
+	if !ok || c.Checksum() == "" {
+		return nil
+	}
+
+	algo, remote, _ := strings.Cut(c.Checksum(), ":")
+	local, ok := sums[algo]
+	if !ok {
+		lgr.Default().Logf("[WARN] unsupported checksum %s for file %s, skipping verification", algo, file.Name)
+		return nil
+	}
+
+	if local != remote {
+		return fmt.Errorf("%w: expected %s:%s, got %s:%s", engine.ErrChecksumMismatch, algo, local, algo, remote)
+	}
+
+	lgr.Default().Logf("[DEBUG] uploaded file %s checksum verified (%s)", file.Name, algo)
+	return nil
+}
+
+// checksums - вычисляет контрольные суммы файла, которые
+// может предоставить сервер
+func checksums(r io.ReaderAt) (map[string]string, error) {
+	hashes := map[string]hash.Hash{
+		"md5":    md5.New(),
+		"sha1":   sha1.New(),
+		"sha256": sha256.New(),
+	}
+
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+
+	_, err := io.Copy(io.MultiWriter(writers...), io.NewSectionReader(r, 0, 1<<63-1))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string, len(hashes))
+	for algo, h := range hashes {
+		result[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return result, nil
+}
+
+// expect - закрывает ответ и возвращает ошибку,
+// если код ответа не входит в codes
+func expect(resp *http.Response, err error, op, name string, codes ...int) error {
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if slices.Contains(codes, resp.StatusCode) {
+		return nil
+	}
+
+	return retry.WithAfter(gowebdav.NewPathError(op, name, resp.StatusCode), resp)
+}
+
+func limiter(ctx context.Context, conf engine.Config) func(io.Reader) io.Reader {
+	if conf.Throttle != nil {
+		return conf.Throttle.Limit(ctx)
+	}
+
+	return func(r io.Reader) io.Reader { return r }
+}
+
+// Ограничения размера части Nextcloud chunking v2,
+// последняя часть может быть меньше MinChunkSize
+const (
+	MinChunkSize = 5 << 20
+	MaxChunkSize = 5 << 30
+)
+
+// CheckChunkSize - проверяет, что сервер примет части размера size
+func CheckChunkSize(size int64) error {
+	if size < MinChunkSize || size > MaxChunkSize {
+		return fmt.Errorf("chunk size %d is out of nextcloud range from %d to %d bytes", size, MinChunkSize, MaxChunkSize)
+	}
+
+	return nil
+}
+
+// layout - разбиение файла на части
+type layout struct {
+	size  int64
+	chunk int64
+	count int64
+}
+
+func newLayout(file engine.File) layout {
+	l := layout{size: file.Size, chunk: file.PartitionSize}
+	if l.chunk <= 0 {
+		l.chunk = engine.DefaultPartitionSize
+	}
+
+	l.count = (l.size + l.chunk - 1) / l.chunk
+	return l
+}
+
+// sizeOf - возвращает размер части с указанным индексом
+func (l layout) sizeOf(index int64) int64 {
+	return min(l.chunk, l.size-l.offsetOf(index))
+}
+
+// offsetOf - возвращает смещение части с указанным индексом
+func (l layout) offsetOf(index int64) int64 {
+	return (index - 1) * l.chunk
+}
+
+// chunkName - имя части на сервере, Nextcloud объединяет
+// части в порядке имен
+func chunkName(index int64) string {
+	return fmt.Sprintf("%05d", index)
+}
+
+// makeProgress - формирует прогресс выгрузки файла, скорость считается
+// по частям выгруженным в рамках текущей попытки
+func makeProgress(file engine.File, done, count, sent int64, duration time.Duration) engine.Progress {
+	progress := engine.Progress{
+		ID:      file.ID,
+		Name:    file.Name,
+		Percent: float64(done) / float64(count) * 100,
+	}
+
+	if seconds := duration.Seconds(); seconds > 0 {
+		progress.Speed = int64(float64(sent) / seconds)
+	}
+
+	return progress
+}
+
+// sendProgress - отправляет прогресс, пока выгрузка не отменена
+//
+// Прогресс не читается после остановки движка,
+// ожидание отправки не должно блокировать воркер
+func sendProgress(ctx context.Context, pch chan<- engine.Progress, progress engine.Progress) {
+	if pch == nil {
+		return
+	}
+
+	select {
+	case pch <- progress:
+	case <-ctx.Done():
+	}
+}
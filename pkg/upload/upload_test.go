@@ -0,0 +1,281 @@
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/dav"
+	"github.com/ReanSn0w/wddl/pkg/davtest"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/queue"
+	"github.com/ReanSn0w/wddl/pkg/upload"
+	"github.com/go-pkgz/lgr"
+	"github.com/studio-b12/gowebdav"
+)
+
+func newUploader(t *testing.T, srv *davtest.Server, chunked bool) *upload.Uploader {
+	t.Helper()
+
+	client := srv.Client()
+	if !chunked {
+		return upload.New(client, nil)
+	}
+
+	if err := client.MkdirAll("/uploads", 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	endpoint := srv.URL + "/uploads"
+	return upload.New(client, dav.New(endpoint, gowebdav.NewAutoAuth("", ""), false))
+}
+
+func writeLocal(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	writeLocal(t, dir+"/outbox", map[string][]byte{
+		"a.txt":     []byte("a"),
+		"sub/b.txt": []byte("bb"),
+	})
+
+	if err := os.Symlink(dir+"/outbox/a.txt", dir+"/outbox/link.txt"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	conf := engine.Config{InputPath: dir + "/outbox", OutputPath: "/remote", TempPath: dir + "/temp"}
+	files, err := upload.New(nil, nil).Scan(context.Background(), conf, conf.InputPath)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	var dests []string
+	for _, file := range files {
+		dests = append(dests, file.Dest)
+	}
+
+	slices.Sort(dests)
+	if !slices.Equal(dests, []string{"/remote/a.txt", "/remote/sub/b.txt"}) {
+		t.Errorf("Scan() destinations = %v", dests)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	local := map[string][]byte{
+		"small.txt":   []byte("hello"),
+		"empty.txt":   {},
+		"dir/big.bin": bytes.Repeat([]byte("0123456789"), 1000),
+	}
+	writeLocal(t, dir, local)
+
+	conf := engine.Config{InputPath: dir, OutputPath: "/outbox", VerifyChecksum: true}
+	u := newUploader(t, srv, false)
+
+	files, err := u.Scan(context.Background(), conf, dir)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	for _, file := range files {
+		checksum, err := u.Download(context.Background(), conf, nil, file)
+		if err != nil {
+			t.Fatalf("Download(%s) error = %v", file.Name, err)
+		}
+
+		data, err := srv.ReadFile(file.Dest)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", file.Dest, err)
+		}
+
+		expected := local[file.Dest[len("/outbox/"):]]
+		if !bytes.Equal(data, expected) {
+			t.Errorf("uploaded %s content mismatch", file.Dest)
+		}
+
+		sum := sha256.Sum256(expected)
+		if checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("Download(%s) checksum = %s", file.Name, checksum)
+		}
+
+		if _, err := u.Stat(file.Dest); err != nil {
+			t.Errorf("Stat(%s) error = %v", file.Dest, err)
+		}
+	}
+
+	if _, err := u.Stat("/outbox/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() of missing file error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestUploadCancel(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeLocal(t, dir, map[string][]byte{"small.txt": []byte("hello")})
+
+	conf := engine.Config{InputPath: dir, OutputPath: "/outbox"}
+	u := newUploader(t, srv, false)
+
+	files, err := u.Scan(context.Background(), conf, dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Scan() = %v, %v", files, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := u.Download(ctx, conf, make(chan engine.Progress), files[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("Download() error = %v, want context canceled", err)
+	}
+
+	if srv.Exists("/outbox/small.txt") {
+		t.Errorf("canceled upload should not be sent")
+	}
+}
+
+func TestCheckChunkSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		ok   bool
+	}{
+		{size: 1024, ok: false},
+		{size: upload.MinChunkSize, ok: true},
+		{size: 64 << 20, ok: true},
+		{size: upload.MaxChunkSize + 1, ok: false},
+	}
+
+	for _, tt := range tests {
+		if err := upload.CheckChunkSize(tt.size); (err == nil) != tt.ok {
+			t.Errorf("CheckChunkSize(%d) error = %v, want ok %v", tt.size, err, tt.ok)
+		}
+	}
+}
+
+func TestUploadChunksResume(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("abcdefghij"), 300)
+	writeLocal(t, dir, map[string][]byte{"movie.mkv": data})
+
+	conf := engine.Config{InputPath: dir, OutputPath: "/outbox", PartitionSize: 1024}
+	u := newUploader(t, srv, true)
+
+	files, err := u.Scan(context.Background(), conf, dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Scan() = %v, %v", files, err)
+	}
+
+	file := files[0]
+	srv.Inject(davtest.Fault{Kind: davtest.FaultStatus, Method: "PUT", Path: "/uploads/wddl-" + file.ID + "/00002", Status: 500})
+
+	if _, err := u.Download(context.Background(), conf, nil, file); err == nil {
+		t.Fatalf("Download() should fail on injected fault")
+	}
+
+	if got := srv.Requests("PUT"); got != 2 {
+		t.Errorf("first attempt sent %d chunks, want 2", got)
+	}
+
+	if _, err := u.Download(context.Background(), conf, nil, file); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	// Первая часть уже выгружена и не отправляется повторно
+	if got := srv.Requests("PUT"); got != 4 {
+		t.Errorf("resumed upload sent %d chunks in total, want 4", got)
+	}
+
+	uploaded, err := srv.ReadFile("/outbox/movie.mkv")
+	if err != nil || !bytes.Equal(uploaded, data) {
+		t.Errorf("assembled file mismatch: %v", err)
+	}
+
+	if srv.Exists("/uploads/wddl-" + file.ID) {
+		t.Errorf("upload directory should be removed after assembly")
+	}
+}
+
+func TestUploadEngine(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeLocal(t, dir+"/outbox", map[string][]byte{
+		"new.txt":     []byte("new"),
+		"present.txt": []byte("present"),
+	})
+
+	if err := srv.WriteFile("/remote/present.txt", []byte("present")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	u := newUploader(t, srv, false)
+	conf := engine.Config{
+		InputPath:    dir + "/outbox",
+		OutputPath:   "/remote",
+		TempPath:     dir + "/temp",
+		Concurrency:  1,
+		ScanEvery:    time.Hour,
+		RemoveRemote: true,
+		MaxFailures:  1,
+		HistoryMode:  engine.HistoryModeMirror,
+		Target:       u,
+	}
+
+	q, err := queue.New(dir + "/upload.db")
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	summary, err := engine.New(lgr.New(), conf, u, u, q, q).Once(ctx)
+	if err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+
+	if summary.Files != 1 || summary.Failed != 0 {
+		t.Errorf("Once() summary = %s", summary)
+	}
+
+	if !srv.Exists("/remote/new.txt") {
+		t.Errorf("new.txt should be uploaded")
+	}
+
+	if _, err := os.Stat(dir + "/outbox/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("new.txt should be deleted locally after upload, stat error = %v", err)
+	}
+
+	// Файл уже есть на сервере и не выгружается, поэтому не удаляется
+	if _, err := os.Stat(dir + "/outbox/present.txt"); err != nil {
+		t.Errorf("present.txt should be kept: %v", err)
+	}
+}
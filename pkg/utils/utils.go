@@ -1,14 +1,24 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
-	"github.com/studio-b12/gowebdav"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/walk"
 )
 
-func New(client *gowebdav.Client, target, source string) *Cleaner {
+// Remote - удаленное хранилище, из которого удаляются загруженные файлы
+type Remote interface {
+	ReadDir(path string) ([]os.FileInfo, error)
+	Remove(path string) error
+}
+
+func New(client Remote, target, source string) *Cleaner {
 	return &Cleaner{
 		Target: target,
 		Source: source,
@@ -20,16 +30,28 @@ type Cleaner struct {
 	Target string
 	Source string
 
-	wd *gowebdav.Client
+	// Количество директорий, читаемых одновременно (0 или 1 - последовательно)
+	Threads int
+
+	// Максимальная глубина вложенности директорий относительно Source (0 - без ограничения)
+	MaxDepth int
+
+	wd Remote
 }
 
+// ClearRemoteFiles - удаляет из удаленного хранилища файлы, уже загруженные в Target
+//
+// Недоступные вложенные директории пропускаются, файлы из остальных
+// директорий удаляются, после чего возвращается *engine.ScanError
 func (c *Cleaner) ClearRemoteFiles() error {
-	files, err := c.scanRemoteFiles(c.Source)
-	if err != nil {
-		return err
+	files, scanErr := c.scanRemoteFiles(c.Source)
+
+	var partial *engine.ScanError
+	if scanErr != nil && !errors.As(scanErr, &partial) {
+		return scanErr
 	}
 
-	files, err = c.filterAlreadyDownloaded(files)
+	files, err := c.filterAlreadyDownloaded(files)
 	if err != nil {
 		return err
 	}
@@ -39,7 +61,40 @@ func (c *Cleaner) ClearRemoteFiles() error {
 		return err
 	}
 
-	return nil
+	return scanErr
+}
+
+// Plan - возвращает файлы, которые удалит ClearRemoteFiles,
+// не изменяя удаленное хранилище
+func (c *Cleaner) Plan() (*engine.Plan, error) {
+	files, scanErr := c.scanRemoteFiles(c.Source)
+
+	var partial *engine.ScanError
+	if scanErr != nil && !errors.As(scanErr, &partial) {
+		return nil, scanErr
+	}
+
+	files, err := c.filterAlreadyDownloaded(files)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := engine.NewPlan()
+	if partial != nil {
+		plan.WarnScan(partial)
+	}
+
+	for _, file := range files {
+		plan.Add(engine.PlanItem{
+			Action:       engine.ActionSkip,
+			Source:       filepath.Join(c.Source, file.CleanPath),
+			Dest:         filepath.Join(c.Target, file.CleanPath),
+			Size:         file.Size,
+			RemoteDelete: true,
+		})
+	}
+
+	return plan, nil
 }
 
 type scannedFile struct {
@@ -79,29 +134,40 @@ func (c *Cleaner) filterAlreadyDownloaded(files []scannedFile) ([]scannedFile, e
 
 func (c *Cleaner) scanRemoteFiles(dir string) ([]scannedFile, error) {
 	var (
+		mx     sync.Mutex
 		result []scannedFile
 	)
 
-	items, err := c.wd.ReadDir(dir)
-	if err != nil {
-		return nil, err
-	}
+	errs := walk.Walk(context.Background(), dir, c.Threads, c.MaxDepth, func(dir string) ([]string, error) {
+		items, err := c.wd.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
 
-	for _, item := range items {
-		switch item.IsDir() {
-		case true:
-			subItems, err := c.scanRemoteFiles(filepath.Join(dir, item.Name()))
-			if err != nil {
-				return nil, err
+		var dirs []string
+		for _, item := range items {
+			switch item.IsDir() {
+			case true:
+				dirs = append(dirs, filepath.Join(dir, item.Name()))
+			case false:
+				mx.Lock()
+				result = append(result, scannedFile{
+					CleanPath: strings.TrimPrefix(filepath.Join(dir, item.Name()), c.Source),
+					Size:      item.Size(),
+				})
+				mx.Unlock()
 			}
-
-			result = append(result, subItems...)
-		case false:
-			result = append(result, scannedFile{
-				CleanPath: strings.TrimPrefix(filepath.Join(dir, item.Name()), c.Source),
-				Size:      item.Size(),
-			})
 		}
+
+		return dirs, nil
+	})
+
+	if err, ok := errs[dir]; ok {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return result, &engine.ScanError{Dirs: errs}
 	}
 
 	return result, nil
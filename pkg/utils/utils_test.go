@@ -0,0 +1,119 @@
+package utils_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ReanSn0w/wddl/pkg/davtest"
+	"github.com/ReanSn0w/wddl/pkg/engine"
+	"github.com/ReanSn0w/wddl/pkg/utils"
+)
+
+func TestClearRemoteFiles(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	remote := map[string]string{
+		"/input/done.txt":       "downloaded",
+		"/input/dir/done.txt":   "downloaded too",
+		"/input/partial.txt":    "partially downloaded",
+		"/input/dir/absent.txt": "not downloaded",
+	}
+
+	for name, data := range remote {
+		if err := srv.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	target := t.TempDir()
+	local := map[string]string{
+		"/done.txt":     "downloaded",
+		"/dir/done.txt": "downloaded too",
+		"/partial.txt":  "partial",
+	}
+
+	for name, data := range local {
+		path := filepath.Join(target, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	cleaner := utils.New(srv.Client(), target, "/input")
+
+	plan, err := cleaner.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	if plan.RemoteDelete != (engine.Total{Files: 2, Bytes: 24}) {
+		t.Errorf("Plan() RemoteDelete = %+v, want 2 files", plan.RemoteDelete)
+	}
+
+	if !srv.Exists("/input/done.txt") {
+		t.Errorf("Plan() should not remove remote files")
+	}
+
+	err = cleaner.ClearRemoteFiles()
+	if err != nil {
+		t.Fatalf("ClearRemoteFiles() error = %v", err)
+	}
+
+	want := map[string]bool{
+		"/input/done.txt":       false,
+		"/input/dir/done.txt":   false,
+		"/input/partial.txt":    true,
+		"/input/dir/absent.txt": true,
+	}
+
+	for name, exists := range want {
+		if srv.Exists(name) != exists {
+			t.Errorf("remote %s exists = %v, want %v", name, !exists, exists)
+		}
+	}
+}
+
+func TestClearRemoteFilesPartial(t *testing.T) {
+	srv := davtest.NewServer()
+	defer srv.Close()
+
+	target := t.TempDir()
+	for _, name := range []string{"/done.txt", "/dir/done.txt"} {
+		if err := srv.WriteFile("/input"+name, []byte("downloaded")); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+
+		path := filepath.Join(target, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte("downloaded"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	srv.Inject(davtest.Fault{Kind: davtest.FaultStatus, Method: "PROPFIND", Path: "/input/dir", Status: 403})
+
+	cleaner := utils.New(srv.Client(), target, "/input")
+	cleaner.Threads = 2
+
+	var partial *engine.ScanError
+	if err := cleaner.ClearRemoteFiles(); !errors.As(err, &partial) {
+		t.Fatalf("ClearRemoteFiles() error = %v, want partial scan", err)
+	}
+
+	// Файлы из доступных директорий удаляются несмотря на ошибку
+	if srv.Exists("/input/done.txt") {
+		t.Errorf("/input/done.txt should be removed")
+	}
+
+	if !srv.Exists("/input/dir/done.txt") {
+		t.Errorf("/input/dir/done.txt should be kept")
+	}
+}
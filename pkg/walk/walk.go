@@ -0,0 +1,137 @@
+// Package walk выполняет параллельный обход дерева директорий
+package walk
+
+import (
+	"context"
+	"sync"
+)
+
+// Walk - обходит дерево, начиная с root, обрабатывая не более
+// threads узлов одновременно (0 или 1 - последовательно)
+//
+// visit обрабатывает узел и возвращает вложенные узлы. Ошибка visit
+// записывается в результат, обход остальных узлов продолжается.
+// Узлы глубже maxDepth (корень имеет глубину 0, 0 - без ограничения)
+// не обрабатываются. После отмены ctx новые узлы не обрабатываются,
+// для ожидавших обработки узлов в результат записывается ctx.Err()
+func Walk[T comparable](ctx context.Context, root T, threads, maxDepth int, visit func(node T) ([]T, error)) map[T]error {
+	w := &walker[T]{
+		ctx:      ctx,
+		maxDepth: maxDepth,
+		visit:    visit,
+		queue:    []item[T]{{node: root}},
+		pending:  1,
+		errs:     make(map[T]error),
+	}
+
+	w.cond = sync.NewCond(&w.mx)
+
+	// Ожидающие воркеры должны узнать об отмене
+	stop := context.AfterFunc(ctx, func() {
+		w.mx.Lock()
+		defer w.mx.Unlock()
+
+		w.cond.Broadcast()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for range max(threads, 1) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+
+	wg.Wait()
+	return w.errs
+}
+
+type item[T comparable] struct {
+	node  T
+	depth int
+}
+
+// walker - состояние обхода, общее для воркеров
+type walker[T comparable] struct {
+	ctx      context.Context
+	maxDepth int
+	visit    func(node T) ([]T, error)
+
+	mx   sync.Mutex
+	cond *sync.Cond
+
+	// Узлы, ожидающие обработки
+	queue []item[T]
+
+	// Количество ожидающих и обрабатываемых узлов
+	pending int
+
+	errs map[T]error
+}
+
+// work - обрабатывает узлы очереди, пока обход не завершен
+func (w *walker[T]) work() {
+	for {
+		current, ok := w.next()
+		if !ok {
+			return
+		}
+
+		children, err := w.visit(current.node)
+		w.done(current, children, err)
+	}
+}
+
+// next - ожидает следующий узел, false - обход завершен или отменен
+func (w *walker[T]) next() (item[T], bool) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	for len(w.queue) == 0 && w.pending > 0 && w.ctx.Err() == nil {
+		w.cond.Wait()
+	}
+
+	if err := w.ctx.Err(); err != nil {
+		for _, skipped := range w.queue {
+			w.errs[skipped.node] = err
+		}
+
+		w.pending -= len(w.queue)
+		w.queue = nil
+		return item[T]{}, false
+	}
+
+	if len(w.queue) == 0 {
+		return item[T]{}, false
+	}
+
+	// Последний добавленный узел обрабатывается первым: обход идет
+	// в глубину, и очередь не накапливает целые уровни дерева
+	current := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return current, true
+}
+
+// done - записывает результат обработки узла
+func (w *walker[T]) done(current item[T], children []T, err error) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	w.pending--
+
+	switch {
+	case err != nil:
+		w.errs[current.node] = err
+	case w.maxDepth <= 0 || current.depth < w.maxDepth:
+		for _, child := range children {
+			w.queue = append(w.queue, item[T]{node: child, depth: current.depth + 1})
+		}
+
+		w.pending += len(children)
+	}
+
+	w.cond.Broadcast()
+}
@@ -0,0 +1,125 @@
+package walk_test
+
+import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/walk"
+)
+
+// tree - директории и их вложенные директории
+var tree = map[string][]string{
+	"/":         {"/a", "/b", "/c"},
+	"/a":        {"/a/x", "/a/y"},
+	"/a/x":      {"/a/x/deep"},
+	"/a/x/deep": nil,
+	"/a/y":      nil,
+	"/b":        {"/b/z"},
+	"/b/z":      nil,
+	"/c":        nil,
+}
+
+func TestWalk(t *testing.T) {
+	var (
+		mx      sync.Mutex
+		visited []string
+		active  atomic.Int32
+		peak    atomic.Int32
+	)
+
+	errs := walk.Walk(context.Background(), "/", 2, 0, func(dir string) ([]string, error) {
+		current := active.Add(1)
+		defer active.Add(-1)
+
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond * 10)
+
+		mx.Lock()
+		visited = append(visited, dir)
+		mx.Unlock()
+
+		if dir == "/b" {
+			return nil, errors.New("forbidden")
+		}
+
+		return tree[dir], nil
+	})
+
+	if len(errs) != 1 || errs["/b"] == nil {
+		t.Errorf("Walk() errors = %v, want only /b", errs)
+	}
+
+	slices.Sort(visited)
+	expected := []string{"/", "/a", "/a/x", "/a/x/deep", "/a/y", "/b", "/c"}
+	if !slices.Equal(visited, expected) {
+		t.Errorf("visited %v, want %v", visited, expected)
+	}
+
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrency = %d, want 2", got)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	var (
+		mx      sync.Mutex
+		visited []string
+	)
+
+	walk.Walk(context.Background(), "/", 4, 1, func(dir string) ([]string, error) {
+		mx.Lock()
+		visited = append(visited, dir)
+		mx.Unlock()
+
+		return tree[dir], nil
+	})
+
+	for _, dir := range visited {
+		if dir != "/" && path.Dir(dir) != "/" {
+			t.Errorf("directory %s is deeper than max depth", dir)
+		}
+	}
+
+	if len(visited) != 4 {
+		t.Errorf("visited %v, want root and its 3 directories", visited)
+	}
+}
+
+func TestWalkCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var visited atomic.Int32
+	errs := walk.Walk(ctx, "/", 2, 0, func(dir string) ([]string, error) {
+		visited.Add(1)
+
+		// Обход отменяется после чтения корня
+		if dir == "/" {
+			cancel()
+		}
+
+		return tree[dir], nil
+	})
+
+	if got := visited.Load(); got != 1 {
+		t.Errorf("visited %d directories after cancel, want only root", got)
+	}
+
+	for _, dir := range tree["/"] {
+		if !errors.Is(errs[dir], context.Canceled) {
+			t.Errorf("Walk() error of %s = %v, want context canceled", dir, errs[dir])
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package window
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Parse - разбирает список окон загрузки
+//
+// Окно записывается в виде "[дни] [ЧЧ:ММ-ЧЧ:ММ]":
+//   - "mon-fri 19:00-08:00" - будние дни с вечера до утра
+//   - "sat,sun" - выходные целиком
+//   - "22:00-06:00" - каждую ночь
+//
+// Окно, переходящее через полночь, относится к дню своего начала
+func Parse(items []string) (*Windows, error) {
+	w := &Windows{}
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		window, err := parse(item)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", item, err)
+		}
+
+		w.items = append(w.items, window)
+	}
+
+	return w, nil
+}
+
+// Windows - набор окон, в которые разрешена загрузка
+type Windows struct {
+	items []window
+}
+
+// Active - проверяет, что момент времени входит хотя бы в одно окно
+// пустой набор окон разрешает загрузку в любое время
+func (w *Windows) Active(t time.Time) bool {
+	if len(w.items) == 0 {
+		return true
+	}
+
+	for _, item := range w.items {
+		if item.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type window struct {
+	days [7]bool
+	from time.Duration
+	to   time.Duration
+}
+
+func (w window) contains(t time.Time) bool {
+	at := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.from <= w.to {
+		return w.days[t.Weekday()] && at >= w.from && at < w.to
+	}
+
+	// Окно через полночь: вечерняя часть относится к текущему дню,
+	// утренняя - к предыдущему
+	if at >= w.from {
+		return w.days[t.Weekday()]
+	}
+
+	return at < w.to && w.days[(t.Weekday()+6)%7]
+}
+
+func parse(item string) (window, error) {
+	w := window{to: 24 * time.Hour}
+	for i := range w.days {
+		w.days[i] = true
+	}
+
+	for _, field := range strings.Fields(strings.ToLower(item)) {
+		var err error
+
+		switch {
+		case field == "*":
+			continue
+		case strings.Contains(field, ":"):
+			w.from, w.to, err = parsePeriod(field)
+		default:
+			w.days, err = parseDays(field)
+		}
+
+		if err != nil {
+			return window{}, err
+		}
+	}
+
+	return w, nil
+}
+
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+
+	for _, part := range strings.Split(value, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+
+		start, ok := weekdays[from]
+		if !ok {
+			return days, fmt.Errorf("invalid weekday %q", from)
+		}
+
+		end := start
+		if isRange {
+			end, ok = weekdays[to]
+			if !ok {
+				return days, fmt.Errorf("invalid weekday %q", to)
+			}
+		}
+
+		for day := start; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == end {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parsePeriod(value string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start, end, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,62 @@
+package window_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ReanSn0w/wddl/pkg/window"
+)
+
+func TestActive(t *testing.T) {
+	w, err := window.Parse([]string{"mon-fri 19:00-08:00", "sat,sun"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	// 2024-01-01 - понедельник
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{name: "Monday before window", at: at(1, 7, 0), want: false},
+		{name: "Monday business hours", at: at(1, 12, 0), want: false},
+		{name: "Monday evening", at: at(1, 19, 0), want: true},
+		{name: "Tuesday night continues Monday window", at: at(2, 3, 0), want: true},
+		{name: "Tuesday window end", at: at(2, 8, 0), want: false},
+		{name: "Saturday noon", at: at(6, 12, 0), want: true},
+		{name: "Saturday night continues Friday window", at: at(6, 2, 0), want: true},
+		{name: "Monday night after whole-day Sunday", at: at(8, 2, 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := w.Active(tt.at); got != tt.want {
+				t.Errorf("Active(%s) = %v, want %v", tt.at.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	w, err := window.Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !w.Active(time.Now()) {
+		t.Error("empty windows should always be active")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, item := range []string{"monday", "mon 9-18", "mon 25:00-26:00"} {
+		if _, err := window.Parse([]string{item}); err == nil {
+			t.Errorf("Parse(%q) should fail", item)
+		}
+	}
+}